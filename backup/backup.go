@@ -58,6 +58,12 @@ type Backup interface {
 
 type Backups []Backup
 
+// Pather is implemented by backups that are stored at a
+// path, such as an archive file or a ZFS snapshot
+type Pather interface {
+	Path() string
+}
+
 func (bs Backups) Len() int {
 	return len(bs)
 }
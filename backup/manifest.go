@@ -0,0 +1,177 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ManifestExtension is appended to the name of a backup
+// to form the file name of its manifest
+const ManifestExtension = ".manifest.json"
+
+// ManifestFile records a single file contained within a backup
+type ManifestFile struct {
+	// Slash-separated path relative to the backed up directory
+	Name    string      `json:"name"`
+	Mode    os.FileMode `json:"mode"`
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mtime"`
+	Link    string      `json:"link,omitempty"`
+	SHA256  string      `json:"sha256,omitempty"`
+}
+
+// Manifest records everything that went into a backup so that
+// the backup can later be checked for corruption
+type Manifest struct {
+	Name     string         `json:"name"`
+	Created  time.Time      `json:"created"`
+	Files    []ManifestFile `json:"files"`
+	Checksum string         `json:"checksum"`
+}
+
+func NewManifest(name string, when time.Time) *Manifest {
+	return &Manifest{
+		Name:    name,
+		Created: when,
+	}
+}
+
+// Add records a file in the manifest, hashing the contents of regular files
+// by reading r to the end. The number of bytes read from r is returned.
+func (m *Manifest) Add(name string, info os.FileInfo, link string, r io.Reader) (int64, error) {
+	file := ManifestFile{
+		Name:    name,
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+		Link:    link,
+	}
+
+	var n int64
+	if info.Mode().IsRegular() {
+		h := sha256.New()
+		var err error
+		n, err = io.Copy(h, r)
+		if err != nil {
+			return n, err
+		}
+		file.Size = n
+		file.SHA256 = hex.EncodeToString(h.Sum(nil))
+	}
+
+	m.Files = append(m.Files, file)
+	return n, nil
+}
+
+// Finish sorts the files and computes the total checksum.
+// It must be called once all files have been added.
+func (m *Manifest) Finish() {
+	sort.Slice(m.Files, func(i, j int) bool {
+		return m.Files[i].Name < m.Files[j].Name
+	})
+	m.Checksum = m.sum()
+}
+
+// sum hashes the name, type, size and contents of every file. Modification
+// times and permissions aren't included, as not all formats store them
+// precisely enough to be compared.
+func (m *Manifest) sum() string {
+	h := sha256.New()
+	for _, f := range m.Files {
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%s\x00%s\n",
+			f.Name, f.Mode&os.ModeType, f.Size, f.Link, f.SHA256)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Write saves the manifest as JSON
+func (m *Manifest) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	err := json.NewDecoder(r).Decode(&m)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// VerifyError lists every difference found between a backup and its manifest
+type VerifyError struct {
+	Problems []string
+}
+
+func (e *VerifyError) Error() string {
+	const maxShown = 5
+	shown := e.Problems
+	if len(shown) > maxShown {
+		shown = shown[:maxShown]
+	}
+	msg := fmt.Sprintf("%d problems found: %s", len(e.Problems), strings.Join(shown, "; "))
+	if len(e.Problems) > maxShown {
+		msg += "; ..."
+	}
+	return msg
+}
+
+// Verify compares the manifest against one built from the actual contents
+// of the backup, returning a *VerifyError if they differ
+func (m *Manifest) Verify(actual *Manifest) error {
+	var problems []string
+
+	if m.Checksum != m.sum() {
+		problems = append(problems, "manifest checksum does not match its contents")
+	}
+
+	found := make(map[string]ManifestFile, len(actual.Files))
+	for _, f := range actual.Files {
+		found[f.Name] = f
+	}
+
+	for _, want := range m.Files {
+		got, ok := found[want.Name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: missing", want.Name))
+			continue
+		}
+		delete(found, want.Name)
+
+		switch {
+		case got.Mode&os.ModeType != want.Mode&os.ModeType:
+			problems = append(problems, fmt.Sprintf("%s: type changed from %s to %s",
+				want.Name, want.Mode.Type(), got.Mode.Type()))
+		case got.Size != want.Size:
+			problems = append(problems, fmt.Sprintf("%s: size is %d, should be %d",
+				want.Name, got.Size, want.Size))
+		case got.SHA256 != want.SHA256:
+			problems = append(problems, fmt.Sprintf("%s: sha256 checksum mismatch", want.Name))
+		case got.Link != want.Link:
+			problems = append(problems, fmt.Sprintf("%s: link target is %s, should be %s",
+				want.Name, got.Link, want.Link))
+		}
+	}
+
+	var extra []string
+	for name := range found {
+		extra = append(extra, name)
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		problems = append(problems, fmt.Sprintf("%s: not in manifest", name))
+	}
+
+	if len(problems) > 0 {
+		return &VerifyError{Problems: problems}
+	}
+	return nil
+}
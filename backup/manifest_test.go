@@ -0,0 +1,61 @@
+package backup
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeInfo struct {
+	mode os.FileMode
+}
+
+func (fi fakeInfo) Name() string       { return "" }
+func (fi fakeInfo) Size() int64        { return 0 }
+func (fi fakeInfo) Mode() os.FileMode  { return fi.mode }
+func (fi fakeInfo) ModTime() time.Time { return time.Time{} }
+func (fi fakeInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi fakeInfo) Sys() interface{}   { return nil }
+
+func buildManifest(t *testing.T, files map[string]string) *Manifest {
+	m := NewManifest("mcb-test", time.Time{})
+	for name, content := range files {
+		_, err := m.Add(name, fakeInfo{0644}, "", strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Finish()
+	return m
+}
+
+func TestManifestVerify(t *testing.T) {
+	want := buildManifest(t, map[string]string{
+		"level.dat":  "level",
+		"server.jar": "jar",
+	})
+
+	err := want.Verify(buildManifest(t, map[string]string{
+		"server.jar": "jar",
+		"level.dat":  "level",
+	}))
+	if err != nil {
+		t.Errorf("Verify() of identical contents -> %v", err)
+	}
+
+	err = want.Verify(buildManifest(t, map[string]string{
+		"level.dat": "LEVEL",
+		"extra.txt": "",
+	}))
+	verr, ok := err.(*VerifyError)
+	if !ok || len(verr.Problems) != 3 {
+		t.Fatalf("Verify() of changed contents -> %v, should find 3 problems", err)
+	}
+
+	want.Files[0].SHA256 = ""
+	err = want.Verify(buildManifest(t, nil))
+	if err == nil || !strings.Contains(err.Error(), "manifest checksum") {
+		t.Errorf("Verify() of tampered manifest -> %v, should fail its checksum", err)
+	}
+}
@@ -8,27 +8,126 @@ import (
 )
 
 type Options struct {
-	Host         string `short:"H" long:"host" description:"Minecraft server host address" env:"RCON_HOST" required:"true"`
-	Port         uint   `short:"p" long:"port" description:"Minecraft server RCON port" env:"RCON_PORT" default:"25575"`
-	Password     string `short:"P" long:"password" description:"Minecraft server RCON password" env:"RCON_PASS" required:"true"`
-	Provider     string `long:"provider" description:"Backup provider, for taking/storing backups" env:"BACKUP_PROVIDER" default:"tar" choice:"zfs" choice:"zip" choice:"tar"`
-	DryRun       bool   `short:"d" long:"dry-run" description:"Prevent performing any potentially catastrophic operations, only simulate them"`
-	BackupPrefix string `long:"backup-prefix" description:"Identifying prefix for mcbackup-managed backups" env:"BACKUP_PREFIX" default:"mcb-"`
-	BackupFormat string `long:"date-format" description:"Format for snapshot names (see date(1))" env:"BACKUP_FORMAT" default:"%F-%H:%M"`
-	LogLevel     string `short:"l" long:"level" description:"log level verbosity" env:"LOG_LEVEL" choice:"warn" choice:"info" choice:"debug" choice:"trace" default:"info"`
+	preOptions
+	file configFile
 
-	MetricsAddr string `short:"m" long:"metrics" description:"Address to serve Prometheus metrics from, or disabled if unspecified" env:"METRICS_ADDR"`
+	// Set for the options of a provider that backups are replicated to
+	replica bool
+
+	Host         string   `short:"H" long:"host" description:"Minecraft server host address" env:"RCON_HOST" required:"true"`
+	Port         uint     `short:"p" long:"port" description:"Minecraft server RCON port" env:"RCON_PORT" default:"25575"`
+	Password     string   `short:"P" long:"password" description:"Minecraft server RCON password" env:"RCON_PASS" required:"true" default-mask:"-"`
+	Provider     string   `long:"provider" description:"Backup provider, for taking/storing backups" env:"BACKUP_PROVIDER" default:"tar" choice:"zfs" choice:"zip" choice:"tar" choice:"s3" choice:"sftp"`
+	Replicate    []string `long:"replicate" description:"Provider to copy each new backup to, configured by its section under replicas in the config file. Can be given multiple times" env:"REPLICATE" env-delim:"," choice:"tar" choice:"zip" choice:"s3" choice:"sftp"`
+	DryRun       bool     `short:"d" long:"dry-run" description:"Prevent performing any potentially catastrophic operations, only simulate them"`
+	BackupPrefix string   `long:"backup-prefix" description:"Identifying prefix for mcbackup-managed backups" env:"BACKUP_PREFIX" default:"mcb-"`
+	BackupFormat string   `long:"date-format" description:"Format for snapshot names (see date(1))" env:"BACKUP_FORMAT" default:"%F-%H:%M"`
+	LogLevel     string   `short:"l" long:"level" description:"log level verbosity" env:"LOG_LEVEL" choice:"warn" choice:"info" choice:"debug" choice:"trace" default:"info"`
+
+	SaveFlush   bool          `long:"save-flush" description:"Use 'save-all flush' so that all chunks are written to disk before backing up" env:"SAVE_FLUSH"`
+	SaveTimeout time.Duration `long:"save-timeout" description:"How long to wait for the server to finish saving before aborting the backup" env:"SAVE_TIMEOUT" default:"5m"`
+	ServerLog   string        `long:"server-log" description:"Server log to watch for save completion (default: logs/latest.log in the source directory)" env:"SERVER_LOG"`
+
+	Announce Announce
+	Hooks    Hooks
+	Notify   Notify
+
+	MetricsAddr     string        `short:"m" long:"metrics" description:"Address to serve Prometheus metrics from, or disabled if unspecified" env:"METRICS_ADDR"`
+	MetricsCacheTTL time.Duration `long:"metrics-cache-ttl" description:"How long to reuse the list of backups between metrics scrapes" env:"METRICS_CACHE_TTL" default:"1m"`
+
+	APIAddr  string `long:"api" description:"Address to serve the HTTP control API from whilst running cron, or disabled if unspecified" env:"API_ADDR"`
+	APIToken string `long:"api-token" description:"Bearer token required by the HTTP control API" env:"API_TOKEN" default-mask:"-"`
 
 	Cron struct {
 		Prune
 		CronSchedule string `short:"s" long:"cron-schedule" description:"Cron-like schedule to run backups on" env:"CRON_SCHEDULE" default:"*/15 * * * *"`
 		NoPrune      bool   `long:"no-prune" description:"disable pruning during cron operation" env:"CRON_NO_PRUNE"`
+		Verify       bool   `long:"verify" description:"verify each backup against its manifest after it is taken" env:"CRON_VERIFY"`
+
+		SkipIdle     bool          `long:"skip-idle" description:"skip backups when no players have been online since the last backup" env:"CRON_SKIP_IDLE"`
+		IdleInterval time.Duration `long:"idle-interval" description:"when skipping idle backups, still take a backup at least this often, or 0 to skip indefinitely" env:"CRON_IDLE_INTERVAL" default:"24h"`
+		PollInterval time.Duration `long:"poll-interval" description:"when skipping idle backups, how often to check for players online between backups, or 0 to only check before each backup" env:"CRON_POLL_INTERVAL" default:"1m"`
 	} `command:"cron"`
 
 	Run struct {
 	} `command:"once"`
 
 	Prune Prune `command:"prune"`
+
+	List struct {
+		Prune
+		Output string `short:"o" long:"output" description:"Format to list backups in" env:"LIST_OUTPUT" choice:"table" choice:"json" default:"table"`
+	} `command:"list"`
+
+	Restore struct {
+		Target string `short:"t" long:"target" description:"Directory to restore the backup into. If unspecified, the live server is restored in-place" env:"RESTORE_TARGET"`
+		Args   struct {
+			Name string `positional-arg-name:"backup-name" description:"Name of the backup to restore"`
+		} `positional-args:"true" required:"true"`
+	} `command:"restore"`
+
+	Verify struct {
+		All  bool `short:"a" long:"all" description:"Verify every backup, instead of just one"`
+		Args struct {
+			Name string `positional-arg-name:"backup-name" description:"Name of the backup to verify, defaults to the latest backup"`
+		} `positional-args:"true"`
+	} `command:"verify"`
+
+	Import struct {
+		Target string `short:"t" long:"target" description:"Dataset to receive the imported snapshots into" env:"IMPORT_TARGET" required:"true"`
+		Force  bool   `short:"F" long:"force" description:"Roll the target back to its latest snapshot before receiving, discarding any changes since"`
+		Args   struct {
+			Name string `positional-arg-name:"backup-name" description:"Name of the snapshot to import, along with those it was sent incrementally from. Defaults to the latest snapshot"`
+		} `positional-args:"true"`
+	} `command:"import"`
+
+	Config struct {
+		Check struct {
+		} `command:"check" description:"Check the configuration, and print the effective value of every option"`
+	} `command:"config"`
+}
+
+// Announce configures the messages sent to players around backups.
+// Messages are templates with the backup {{.Name}}, {{.Size}},
+// {{.Elapsed}} time and {{.Error}} available, and an empty message
+// disables that announcement.
+type Announce struct {
+	Enabled bool   `long:"announce" description:"Announce backups to players in-game" env:"ANNOUNCE"`
+	Command string `long:"announce-command" description:"Command used to send announcements" env:"ANNOUNCE_COMMAND" choice:"tellraw" choice:"say" default:"tellraw"`
+	Start   string `long:"announce-start" description:"Message sent before a backup starts" env:"ANNOUNCE_START" default:"Backup starting, expect some lag"`
+	Finish  string `long:"announce-finish" description:"Message sent once a backup completes" env:"ANNOUNCE_FINISH" default:"Backup complete in {{.Elapsed}} ({{.Size}})"`
+	Failure string `long:"announce-failure" description:"Message sent if a backup fails" env:"ANNOUNCE_FAILURE" default:"Backup failed: {{.Error}}"`
+}
+
+// Hooks configures commands run at stages of a backup or prune. Hooks are
+// run by the shell, with details of the backup in MCBACKUP_* environment
+// variables, and an empty command disables that hook.
+type Hooks struct {
+	PreSave    string        `long:"hook-pre-save" description:"Command run before the world is saved for a backup" env:"HOOK_PRE_SAVE"`
+	PreCreate  string        `long:"hook-pre-create" description:"Command run once the world is saved, before the backup is created" env:"HOOK_PRE_CREATE"`
+	PostCreate string        `long:"hook-post-create" description:"Command run after a backup is created" env:"HOOK_POST_CREATE"`
+	OnFailure  string        `long:"hook-on-failure" description:"Command run if a backup fails" env:"HOOK_ON_FAILURE"`
+	PostPrune  string        `long:"hook-post-prune" description:"Command run after old backups are pruned" env:"HOOK_POST_PRUNE"`
+	Timeout    time.Duration `long:"hook-timeout" description:"How long a hook may run before it is killed, or 0 for no limit" env:"HOOK_TIMEOUT" default:"1m"`
+	Abort      bool          `long:"hook-abort" description:"Abort the backup if a pre-save or pre-create hook fails" env:"HOOK_ABORT"`
+}
+
+// Notify configures the notifications sent for backup events
+type Notify struct {
+	Webhooks      []string      `long:"webhook" description:"URL to send notifications to, can be given multiple times. Discord and Slack URLs are detected, otherwise JSON is sent unless the URL is prefixed with discord+ or slack+" env:"WEBHOOK_URLS" env-delim:"," default-mask:"-"`
+	Events        []string      `long:"notify" description:"Events to send notifications for" env:"NOTIFY" env-delim:"," choice:"backup-succeeded" choice:"backup-failed" choice:"prune" choice:"disk-full" default:"backup-failed" default:"disk-full"`
+	RateLimit     time.Duration `long:"notify-rate-limit" description:"Minimum time between notifications for the same event, or 0 for no limit" env:"NOTIFY_RATE_LIMIT" default:"30m"`
+	Retries       uint          `long:"notify-retries" description:"How many times to retry sending a notification" env:"NOTIFY_RETRIES" default:"3"`
+	DiskThreshold uint          `long:"notify-disk-threshold" description:"Percentage of the backup storage in use before a disk-full notification is sent" env:"NOTIFY_DISK_THRESHOLD" default:"90"`
+
+	SMTPHost     string   `long:"smtp-host" description:"SMTP server to send notification emails through, or disabled if unspecified" env:"SMTP_HOST"`
+	SMTPPort     uint     `long:"smtp-port" description:"SMTP server port" env:"SMTP_PORT" default:"587"`
+	SMTPSecurity string   `long:"smtp-security" description:"How to encrypt the SMTP connection" env:"SMTP_SECURITY" choice:"starttls" choice:"tls" choice:"none" default:"starttls"`
+	SMTPUsername string   `long:"smtp-username" description:"Username to authenticate to the SMTP server with, if required" env:"SMTP_USERNAME"`
+	SMTPPassword string   `long:"smtp-password" description:"Password to authenticate to the SMTP server with" env:"SMTP_PASSWORD" default-mask:"-"`
+	SMTPFrom     string   `long:"smtp-from" description:"Address to send notification emails from" env:"SMTP_FROM"`
+	SMTPTo       []string `long:"smtp-to" description:"Address to send notification emails to, can be given multiple times" env:"SMTP_TO" env-delim:","`
+	SMTPDigest   string   `long:"smtp-digest" description:"Cron-like schedule to email a digest of backups and prunes on, or empty to disable" env:"SMTP_DIGEST" default:"0 8 * * *"`
 }
 
 // Prune tracks how many backup should be kept of each age
@@ -0,0 +1,304 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v2"
+)
+
+// preOptions are parsed on their own before everything else, as they
+// choose the values from the config file used for all other options
+type preOptions struct {
+	ConfigFile string `long:"config" description:"YAML or TOML file to read options from. Flags and environment variables take precedence" env:"CONFIG_FILE"`
+	ServerName string `long:"server" description:"Name of the server, choosing one of the servers in the config file if several are defined" env:"SERVER_NAME"`
+}
+
+// configFile holds the values read from a configuration file, keyed by the
+// long option name. Top-level values are global options, and sections hold
+// the options for a command or provider of the same name.
+type configFile map[string]interface{}
+
+// LoadConfigFile reads the configuration file named by the --config option,
+// if there is one. It must be called before ApplyConfig.
+func (opts *Options) LoadConfigFile(args []string) error {
+	var pre preOptions
+	_, err := flags.NewParser(&pre, flags.IgnoreUnknown).ParseArgs(args)
+	if err != nil {
+		return err
+	}
+	opts.preOptions = pre
+	if pre.ConfigFile == "" {
+		return nil
+	}
+
+	file := make(configFile)
+	switch strings.ToLower(filepath.Ext(pre.ConfigFile)) {
+	case ".toml":
+		_, err = toml.DecodeFile(pre.ConfigFile, (*map[string]interface{})(&file))
+	default:
+		err = readYAML(pre.ConfigFile, file)
+	}
+	if err != nil {
+		return fmt.Errorf("reading config file: %v", err)
+	}
+
+	opts.file = file
+	return nil
+}
+
+// ApplyConfig sets the values from the configuration file as the defaults for
+// the options in parser, below any flags or environment variables. An empty
+// section applies the global options and the sections for each command, and
+// otherwise the named section is applied, for example for a provider.
+// Replicas are configured only by the config file, so environment variables
+// are ignored for them.
+func (opts *Options) ApplyConfig(parser *flags.Parser, section string) error {
+	// Environment variables are meant for the main provider, not replicas
+	if opts.replica {
+		eachOption(parser.Command.Group, func(option *flags.Option) {
+			option.EnvDefaultKey = ""
+		})
+	}
+
+	if opts.file == nil {
+		return nil
+	}
+
+	if section != "" {
+		values, err := opts.file.section(section)
+		if err != nil {
+			return err
+		}
+		return applyValues(parser.Command.Group, values, section, true)
+	}
+
+	// Anything that isn't a section must be a global option
+	globals := make(map[string]interface{})
+	for key, value := range opts.file {
+		if !isSection(value) {
+			globals[key] = value
+		}
+	}
+	err := applyValues(parser.Command.Group, globals, "", true)
+	if err != nil {
+		return err
+	}
+
+	return applyCommands(opts.file, parser.Commands())
+}
+
+func readYAML(path string, file configFile) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var raw map[string]interface{}
+	err = yaml.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+	for key, value := range raw {
+		file[key] = normaliseYAML(value)
+	}
+	return nil
+}
+
+// normaliseYAML converts the map[interface{}]interface{} used by the YAML
+// decoder for nested maps into map[string]interface{}, the same as TOML
+func normaliseYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = normaliseYAML(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normaliseYAML(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func applyCommands(file configFile, commands []*flags.Command) error {
+	for _, cmd := range commands {
+		// The prune policy is shared between the commands that use it
+		if cmd.Name != "prune" {
+			prune, err := file.section("prune")
+			if err != nil {
+				return err
+			}
+			err = applyValues(cmd.Group, prune, "prune", false)
+			if err != nil {
+				return err
+			}
+		}
+
+		values, err := file.section(cmd.Name)
+		if err != nil {
+			return err
+		}
+		err = applyValues(cmd.Group, values, cmd.Name, true)
+		if err != nil {
+			return err
+		}
+
+		err = applyCommands(configFile(values), cmd.Commands())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyValues sets the defaults for options in the group by long name.
+// If strict, values that don't match any option are an error.
+func applyValues(group *flags.Group, values map[string]interface{}, section string, strict bool) error {
+	options := make(map[string]*flags.Option)
+	eachOption(group, func(option *flags.Option) {
+		if option.LongName != "" {
+			options[option.LongName] = option
+		}
+	})
+
+	for key, value := range values {
+		if isSection(value) {
+			continue
+		}
+
+		option, ok := options[key]
+		if !ok {
+			if strict {
+				return fmt.Errorf("config file: unknown option '%s'", sectionKey(section, key))
+			}
+			continue
+		}
+
+		defaults, err := toStrings(value)
+		if err != nil {
+			return fmt.Errorf("config file: option '%s': %v", sectionKey(section, key), err)
+		}
+		option.Default = defaults
+	}
+	return nil
+}
+
+// eachOption calls fn for every option in a group, including nested groups
+func eachOption(group *flags.Group, fn func(*flags.Option)) {
+	for _, option := range group.Options() {
+		fn(option)
+	}
+	for _, child := range group.Groups() {
+		eachOption(child, fn)
+	}
+}
+
+func (file configFile) section(name string) (map[string]interface{}, error) {
+	value, ok := file[name]
+	if !ok {
+		return nil, nil
+	}
+	section, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("config file: '%s' must be a section", name)
+	}
+	return section, nil
+}
+
+func isSection(value interface{}) bool {
+	_, ok := value.(map[string]interface{})
+	return ok
+}
+
+func sectionKey(section, key string) string {
+	if section == "" {
+		return key
+	}
+	return section + "." + key
+}
+
+// toStrings converts a value from the config file into the
+// form go-flags expects for defaults
+func toStrings(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		strs := make([]string, 0, len(v))
+		for _, item := range v {
+			if isSection(item) {
+				return nil, fmt.Errorf("expected a list of values")
+			}
+			strs = append(strs, fmt.Sprint(item))
+		}
+		return strs, nil
+	case nil:
+		return nil, nil
+	default:
+		return []string{fmt.Sprint(v)}, nil
+	}
+}
+
+// WriteEffective writes the value of every option as YAML, in the same layout
+// as the configuration file, so that it can be used as one. Values are taken
+// from opts and the provider, so this should be called once both are parsed.
+func (opts *Options) WriteEffective(w io.Writer, provider interface{}) error {
+	parser := flags.NewParser(opts, flags.None)
+	effective := effectiveValues(parser.Command.Group)
+
+	for _, cmd := range parser.Commands() {
+		if values := effectiveValues(cmd.Group); len(values) > 0 {
+			effective = append(effective, yaml.MapItem{Key: cmd.Name, Value: values})
+		}
+	}
+
+	if provider != nil {
+		provParser := flags.NewParser(provider, flags.None)
+		effective = append(effective, yaml.MapItem{
+			Key:   opts.Provider,
+			Value: effectiveValues(provParser.Command.Group),
+		})
+	}
+
+	out, err := yaml.Marshal(effective)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+func effectiveValues(group *flags.Group) yaml.MapSlice {
+	var values yaml.MapSlice
+	eachOption(group, func(option *flags.Option) {
+		if option.LongName == "" || option.LongName == "config" {
+			return
+		}
+
+		value := option.Value()
+		switch v := value.(type) {
+		case time.Duration:
+			value = v.String()
+		case string:
+			// Don't print secrets, which are masked in the help too
+			if option.DefaultMask != "" && v != "" {
+				value = "********"
+			}
+		case []string:
+			if option.DefaultMask != "" && len(v) > 0 {
+				value = []string{"********"}
+			}
+		}
+		values = append(values, yaml.MapItem{Key: option.LongName, Value: value})
+	})
+	return values
+}
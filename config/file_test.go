@@ -0,0 +1,161 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jessevdk/go-flags"
+)
+
+func TestConfigFilePrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcbackup-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "mcbackup.yaml")
+	err = ioutil.WriteFile(path, []byte(`
+host: file
+password: file
+backup-prefix: file-
+prune:
+  keep-daily: 1
+  keep-weekly: 1
+cron:
+  keep-weekly: 2
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("RCON_HOST", "env")
+	defer os.Unsetenv("RCON_HOST")
+
+	var opts Options
+	args := []string{"--config", path, "--backup-prefix", "flag-", "cron"}
+	parser := flags.NewParser(&opts, flags.None)
+	err = opts.LoadConfigFile(args)
+	if err == nil {
+		err = opts.ApplyConfig(parser, "")
+	}
+	if err == nil {
+		_, err = parser.ParseArgs(args)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.BackupPrefix != "flag-" || opts.Host != "env" || opts.Password != "file" {
+		t.Errorf("flags > env > file precedence not respected, got %s, %s, %s",
+			opts.BackupPrefix, opts.Host, opts.Password)
+	}
+	if opts.Cron.KeepDaily != 1 || opts.Cron.KeepWeekly != 2 {
+		t.Errorf("cron prune policy is daily %d, weekly %d, should be 1, 2",
+			opts.Cron.KeepDaily, opts.Cron.KeepWeekly)
+	}
+}
+
+func TestUseServer(t *testing.T) {
+	opts := Options{file: configFile{
+		"port": 25575,
+		"tar":  map[string]interface{}{"backup-dir": "/backups", "tar-compression": "zstd"},
+		"servers": map[string]interface{}{
+			"survival": map[string]interface{}{
+				"port": 25576,
+				"tar":  map[string]interface{}{"source-dir": "/survival"},
+			},
+		},
+	}}
+
+	names, err := opts.ServerNames()
+	if err != nil || len(names) != 1 || names[0] != "survival" {
+		t.Fatalf("ServerNames() -> %v, %v, should be [survival]", names, err)
+	}
+
+	err = opts.UseServer("survival")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tar, _ := opts.file.section("tar")
+	if opts.file["port"] != 25576 || tar["backup-dir"] != "/backups" || tar["source-dir"] != "/survival" {
+		t.Errorf("server options not merged over shared options: %v", opts.file)
+	}
+	if _, ok := opts.file["servers"]; ok {
+		t.Error("servers section should be removed once a server is chosen")
+	}
+}
+
+func TestReplica(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcbackup-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "mcbackup.yaml")
+	err = ioutil.WriteFile(path, []byte(`
+host: file
+password: file
+provider: zfs
+replicate: [tar]
+prune:
+  keep-daily: 7
+  keep-weekly: 2
+replicas:
+  tar:
+    backup-dir: /offsite
+    keep-daily: 30
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Environment variables are for the main provider only
+	os.Setenv("BACKUP_DIRECTORY", "env")
+	defer os.Unsetenv("BACKUP_DIRECTORY")
+
+	var opts Options
+	args := []string{"--config", path, "prune"}
+	parser := flags.NewParser(&opts, flags.IgnoreUnknown)
+	err = opts.LoadConfigFile(args)
+	if err == nil {
+		err = opts.ApplyConfig(parser, "")
+	}
+	if err == nil {
+		_, err = parser.ParseArgs(args)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replica, err := opts.Replica("tar", "/srv/minecraft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replica.Prune.KeepDaily != 30 || replica.Prune.KeepWeekly != 2 {
+		t.Errorf("replica prune policy is daily %d, weekly %d, should be 30, 2",
+			replica.Prune.KeepDaily, replica.Prune.KeepWeekly)
+	}
+	if opts.Prune.KeepDaily != 7 {
+		t.Errorf("replica prune policy changed the main policy")
+	}
+
+	var provider struct {
+		Source string `long:"source-dir" env:"SOURCE_DIRECTORY"`
+		Backup string `long:"backup-dir" env:"BACKUP_DIRECTORY"`
+	}
+	provParser := flags.NewParser(&provider, flags.None)
+	err = replica.ApplyConfig(provParser, "tar")
+	if err == nil {
+		_, err = provParser.ParseArgs(nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.Source != "/srv/minecraft" || provider.Backup != "/offsite" {
+		t.Errorf("replica provider options are %+v", provider)
+	}
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// replicasSection holds a section for each provider that backups are
+// replicated to, keyed by the provider name. Each section holds the options
+// for that provider, along with its prune policy.
+const replicasSection = "replicas"
+
+// IsReplica reports whether the options are for a provider that backups
+// are replicated to, rather than the main provider
+func (opts Options) IsReplica() bool {
+	return opts.replica
+}
+
+// Replica creates the options for a provider that backups are replicated to.
+// The provider is configured only by its section under replicas in the config
+// file, so that it can't be confused with the options for the main provider,
+// and it is pruned by its own policy, which defaults to that of the main
+// provider. Source is the directory backed up, used by archive providers.
+func (opts *Options) Replica(name, source string) (*Options, error) {
+	replicas, err := opts.file.section(replicasSection)
+	if err != nil {
+		return nil, err
+	}
+	values, err := configFile(replicas).section(name)
+	if err != nil {
+		return nil, err
+	}
+
+	replica := *opts
+	replica.Provider = name
+	replica.replica = true
+
+	parser := flags.NewParser(&replica.Prune, flags.None)
+	group := parser.Command.Group
+	isPrune := make(map[string]bool)
+	eachOption(group, func(option *flags.Option) {
+		option.Default = []string{fmt.Sprint(option.Value())}
+		option.EnvDefaultKey = ""
+		isPrune[option.LongName] = true
+	})
+
+	// Anything that isn't part of the prune policy is for the provider
+	prune := make(map[string]interface{})
+	provider := map[string]interface{}{"source-dir": source}
+	for key, value := range values {
+		if isPrune[key] {
+			prune[key] = value
+		} else {
+			provider[key] = value
+		}
+	}
+
+	section := replicasSection + "." + name
+	err = applyValues(group, prune, section, true)
+	if err != nil {
+		return nil, err
+	}
+	_, err = parser.ParseArgs(nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", section, err)
+	}
+
+	replica.file = configFile{name: provider}
+	return &replica, nil
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// serversSection holds a section for each server in the config file, keyed
+// by the server name. Each server's section is laid out the same as the rest
+// of the file, with the values in it overriding those shared by all servers.
+const serversSection = "servers"
+
+// ServerNames lists the servers defined in the config file, if any
+func (opts *Options) ServerNames() ([]string, error) {
+	servers, err := opts.file.section(serversSection)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name, server := range servers {
+		if !isSection(server) {
+			return nil, fmt.Errorf("config file: server '%s' must be a section", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// UseServer applies the values for the named server in the config file
+// over the top of those shared by all servers. It must be called after
+// LoadConfigFile and before ApplyConfig.
+func (opts *Options) UseServer(name string) error {
+	servers, err := opts.file.section(serversSection)
+	if err != nil {
+		return err
+	}
+	server, ok := servers[name].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("no server named '%s' in config file", name)
+	}
+
+	file := mergeSections(opts.file, server)
+	delete(file, serversSection)
+	file["server"] = name
+
+	opts.file = file
+	return nil
+}
+
+// mergeSections copies base, replacing any values set in over. Sections in
+// both are merged, rather than replaced.
+func mergeSections(base, over map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(over))
+	for key, value := range base {
+		merged[key] = value
+	}
+
+	for key, value := range over {
+		baseSection, ok := merged[key].(map[string]interface{})
+		overSection, overOk := value.(map[string]interface{})
+		if ok && overOk {
+			merged[key] = mergeSections(baseSection, overSection)
+		} else {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// ServerLabel identifies the server in metrics
+func (opts Options) ServerLabel() string {
+	return fmt.Sprintf("%s:%d", opts.Host, opts.Port)
+}
@@ -3,31 +3,32 @@ module github.com/spritsail/mcbackup
 go 1.16
 
 require (
+	filippo.io/age v1.0.0-rc.1
+	github.com/BurntSushi/toml v0.3.1
 	github.com/SeerUK/minecraft-rcon v0.0.0-20190221212056-6ab996d90449
+	github.com/aws/aws-sdk-go v1.31.0
 	github.com/bicomsystems/go-libzfs v0.3.4-0.20210120103208-f957d22f5c47
-	github.com/dsnet/compress v0.0.1 // indirect
+	github.com/dsnet/compress v0.0.1
 	github.com/dustin/go-humanize v1.0.0
 	github.com/frankban/quicktest v1.4.1 // indirect
-	github.com/golang/snappy v0.0.1 // indirect
 	github.com/gorhill/cronexpr v0.0.0-20180427100037-88b0669f7d75
 	github.com/jessevdk/go-flags v1.4.0
+	github.com/klauspost/compress v1.13.6
 	github.com/knz/strtime v0.0.0-20200318182718-be999391ffa9
 	github.com/mattn/go-colorable v0.1.6 // indirect
 	github.com/mgutz/ansi v0.0.0-20170206155736-9520e82c474b // indirect
-	github.com/mholt/archiver v3.1.1+incompatible
-	github.com/nwaples/rardecode v1.1.0 // indirect
 	github.com/onsi/ginkgo v1.8.0 // indirect
 	github.com/onsi/gomega v1.5.0 // indirect
-	github.com/pierrec/lz4 v2.5.2+incompatible // indirect
+	github.com/pierrec/lz4 v2.5.2+incompatible
 	github.com/pkg/errors v0.9.1 // indirect
+	github.com/pkg/sftp v1.12.0
 	github.com/prometheus/client_golang v1.6.0
 	github.com/seeruk/minecraft-rcon v0.0.0-20190221212056-6ab996d90449 // indirect
 	github.com/sirupsen/logrus v1.6.0
-	github.com/ulikunitz/xz v0.5.7 // indirect
+	github.com/ulikunitz/xz v0.5.7
 	github.com/x-cray/logrus-prefixed-formatter v0.5.2
-	github.com/xi2/xz v0.0.0-20171230120015-48954b6210f8 // indirect
-	golang.org/x/crypto v0.0.0-20200429183012-4b2356b1ed79 // indirect
-	golang.org/x/net v0.0.0-20190813141303-74dc4d7220e7 // indirect
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 	golang.org/x/sys v0.0.0-20200501145240-bc7a7d42d5c3
 	golang.org/x/text v0.3.2 // indirect
+	gopkg.in/yaml.v2 v2.2.5
 )
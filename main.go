@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/SeerUK/minecraft-rcon/rcon"
 	"github.com/jessevdk/go-flags"
 	"github.com/sirupsen/logrus"
 	"github.com/spritsail/mcbackup/config"
@@ -23,10 +29,218 @@ func init() {
 }
 
 func main() {
+	log := logrus.WithField("prefix", "main")
+	log.Printf("mcbackup, version %s", Version)
+
+	// The config file can define several servers, which are each
+	// configured and backed up independently of one another
+	names, err := serverNames(os.Args[1:])
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+
+	var servers []*server
+	var command *flags.Command
+	for _, name := range names {
+		var srv *server
+		srv, command = configure(os.Args[1:], name)
+		servers = append(servers, srv)
+	}
+
+	if len(servers) > 1 && needsOneServer(command.Name, servers[0].opts) {
+		log.Errorf("%d servers are configured, choose one with --server", len(servers))
+		os.Exit(1)
+	}
+
+	if addr := servers[0].opts.MetricsAddr; addr != "" {
+		for _, srv := range servers {
+			err = prometheus.Register(*srv.opts, srv.prov)
+			if err != nil {
+				log.WithError(err).
+					Fatal("failed to register metrics")
+			}
+		}
+
+		go func() {
+			for {
+				err := prometheus.Serve(addr)
+				log.WithError(err).
+					Error("error serving metrics")
+				time.Sleep(time.Second)
+			}
+		}()
+	}
+
+	// Abort any running operation cleanly when interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// The control API shares the servers with the cron loop
+	var api *mcbackup.API
+	if command.Name == "cron" && servers[0].opts.APIAddr != "" {
+		api = mcbackup.NewAPI(servers[0].opts.APIToken)
+	}
+
+	var wg sync.WaitGroup
+	var failed, ready int
+	for _, srv := range servers {
+		opts := srv.opts
+		log := log
+		if opts.ServerName != "" {
+			log = log.WithField("server", opts.ServerName)
+		}
+
+		var client *rcon.Client
+		if needsRcon(command.Name, opts) {
+			log.Debug("creating client")
+			client, err = mcbackup.NewClient(opts)
+			if err != nil && command.Name == "cron" {
+				// One server being down shouldn't stop the others being
+				// backed up, so connecting is retried before each backup,
+				// which fails and is reported until the server is back
+				client = nil
+				log.WithField("prefix", "rcon").
+					WithError(err).
+					Error("error creating client, retrying before each backup")
+			} else if err != nil {
+				log.WithField("prefix", "rcon").
+					WithError(err).
+					Error("error creating client, skipping server")
+				failed++
+				continue
+			} else {
+				log.WithField("prefix", "rcon").
+					Info("client connection successful")
+			}
+		}
+		ready++
+
+		mcb := mcbackup.New(srv.prov, client, opts)
+		for _, r := range srv.replicas {
+			mcb.AddReplica(r.prov, r.opts)
+		}
+		switch command.Name {
+		case "cron":
+			if api != nil {
+				api.Add(opts.ServerName, mcb)
+			}
+			if opts.MetricsAddr != "" {
+				prometheus.AddReadiness(mcb.ReadinessChecks()...)
+			}
+
+			// Each server runs on its own schedule
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				mcb.Cron(ctx)
+			}()
+			break
+		case "prune":
+			err = mcb.Prune(time.Now())
+			break
+		case "list":
+			err = mcb.List(os.Stdout)
+			break
+		case "restore":
+			err = mcb.Restore(ctx, opts.Restore.Args.Name, opts.Restore.Target)
+			break
+		case "verify":
+			err = mcb.Verify(opts.Verify.Args.Name, opts.Verify.All)
+			break
+		case "import":
+			err = mcb.Import(ctx, opts.Import.Args.Name, opts.Import.Target)
+			break
+		case "config":
+			if len(servers) > 1 {
+				// Separate the servers into multiple YAML documents
+				os.Stdout.WriteString("---\n")
+			}
+			err = opts.WriteEffective(os.Stdout, srv.prov)
+			break
+		default:
+		case "once":
+			log.Info("running a single backup")
+			err = mcb.TakeBackup(ctx, time.Now())
+			break
+		}
+
+		if err != nil {
+			log.WithError(err).Error(":(")
+			failed++
+		}
+	}
+
+	if ready == 0 {
+		log.Error("no servers could be set up")
+		os.Exit(1)
+	}
+
+	if api != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := api.Serve(ctx, servers[0].opts.APIAddr)
+			if err != nil {
+				log.WithError(err).
+					Error("error serving API")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// server is a Minecraft server to back up, with its own options and provider
+type server struct {
+	opts     *config.Options
+	prov     provider.Provider
+	replicas []replica
+}
+
+// replica is a provider that new backups are copied to
+type replica struct {
+	opts *config.Options
+	prov provider.Replica
+}
+
+// serverNames finds the servers to configure. If the config file doesn't
+// define any servers, a single unnamed server is configured from the options.
+func serverNames(args []string) ([]string, error) {
+	var opts config.Options
+	err := opts.LoadConfigFile(args)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := opts.ServerNames()
+	if err != nil || len(names) == 0 {
+		return []string{opts.ServerName}, err
+	}
+
+	if opts.ServerName != "" {
+		for _, name := range names {
+			if name == opts.ServerName {
+				return []string{name}, nil
+			}
+		}
+		return nil, fmt.Errorf("no server named '%s' in config file", opts.ServerName)
+	}
+	return names, nil
+}
+
+// configure parses the options and creates the provider for a server,
+// exiting if anything is invalid
+func configure(args []string, name string) (*server, *flags.Command) {
 	var opts config.Options
 
 	log := logrus.WithField("prefix", "main")
-	log.Printf("mcbackup, version %s", Version)
+	if name != "" {
+		log = log.WithField("server", name)
+	}
 
 	// Parse global commandline options, ignoring anything unknown
 	// so that they can be re-parsed by the provider.
@@ -34,7 +248,21 @@ func main() {
 	parser.Name = "mcbackup"
 	parser.SubcommandsOptional = true
 
-	remain, err := parser.ParseArgs(os.Args[1:])
+	// Options from the config file are used as defaults, below
+	// both commandline options and environment variables
+	err := opts.LoadConfigFile(args)
+	if err == nil && name != "" && opts.ConfigFile != "" {
+		err = opts.UseServer(name)
+	}
+	if err == nil {
+		err = opts.ApplyConfig(parser, "")
+	}
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+
+	remain, err := parser.ParseArgs(args)
 	if err != nil {
 		// Handle 'no command specified' scenario by defaulting to 'once'
 		if e, ok := err.(*flags.Error); ok &&
@@ -85,49 +313,65 @@ func main() {
 		os.Exit(1)
 	}
 
-	if opts.MetricsAddr != "" {
-		go func(opts config.Options, prov provider.Provider) {
-			for {
-				err := prometheus.Serve(opts.MetricsAddr, opts, prov)
-				log.WithError(err).
-					Error("error serving metrics")
-				time.Sleep(time.Second)
-			}
-		}(opts, prov)
-	}
-
-	log.Debug("creating client")
-	client, err := mcbackup.NewClient(&opts)
+	replicas, err := configureReplicas(&opts, prov)
 	if err != nil {
-		logrus.
-			WithField("prefix", "rcon").
-			WithError(err).
-			Fatal("error creating client")
+		log.Error(err)
+		os.Exit(1)
 	}
-	logrus.WithField("prefix", "rcon").
-		Info("client connection successful")
 
-	mcb := mcbackup.New(prov, client, &opts)
 	command := parser.Active
 	if command == nil {
 		command = parser.Find("once")
 	}
-	switch command.Name {
-	case "cron":
-		mcb.Cron()
-		break
-	case "prune":
-		err = mcb.Prune(time.Now())
-		break
+
+	return &server{opts: &opts, prov: prov, replicas: replicas}, command
+}
+
+// configureReplicas creates the providers that new backups are copied to.
+// They are configured only from the config file, so take no arguments.
+func configureReplicas(opts *config.Options, prov provider.Provider) ([]replica, error) {
+	var replicas []replica
+	for _, name := range opts.Replicate {
+		replicaOpts, err := opts.Replica(name, prov.Source())
+		if err != nil {
+			return nil, err
+		}
+		p, _, err := provider.Find(name)(nil, replicaOpts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create replica provider '%s': %v", name, err)
+		}
+		r, ok := p.(provider.Replica)
+		if !ok {
+			return nil, fmt.Errorf("provider '%s' can't store replicated backups", name)
+		}
+		replicas = append(replicas, replica{opts: replicaOpts, prov: r})
+	}
+	return replicas, nil
+}
+
+// needsOneServer determines whether a command only makes sense for a single
+// server, when several are configured
+func needsOneServer(command string, opts *config.Options) bool {
+	switch command {
+	case "restore", "list", "import":
+		return true
+	case "verify":
+		return opts.Verify.Args.Name != ""
 	default:
-	case "once":
-		log.Info("running a single backup")
-		err = mcb.TakeBackup(time.Now())
-		break
+		return false
 	}
+}
 
-	if err != nil {
-		log.WithError(err).
-			Fatalf(":(")
+// needsRcon determines whether a command interacts with the live server
+func needsRcon(command string, opts *config.Options) bool {
+	switch command {
+	case "list", "verify", "config", "import":
+		return false
+	case "restore":
+		// Restoring into a separate directory doesn't touch the live server,
+		// so it shouldn't depend on the server being up
+		return opts.Restore.Target == ""
+	default:
+		return true
 	}
 }
@@ -0,0 +1,92 @@
+package mcbackup
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// playerCountRe matches the response to the list command, which is either
+// "There are 1 of a max of 20 players online: ..." or in older versions
+// "There are 1/20 players online: ..."
+var playerCountRe = regexp.MustCompile(`There are (\d+)(?: of a max of |/)\d+ players online`)
+
+// parsePlayerCount extracts the number of online players from the list command
+func parsePlayerCount(output string) (int, bool) {
+	match := playerCountRe.FindStringSubmatch(output)
+	if match == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(match[1])
+	return n, err == nil
+}
+
+// recordPlayers tracks whether any players have been online since the last
+// backup, from the output of the list command. If the output can't be
+// understood, players are assumed to be online so that backups aren't missed.
+func (mb *mcbackup) recordPlayers(output string) {
+	n, ok := parsePlayerCount(output)
+	if !ok {
+		mb.logger("rcon").
+			WithField("output", output).
+			Debug("unable to parse player list")
+		n = 1
+	}
+
+	mb.activityMu.Lock()
+	defer mb.activityMu.Unlock()
+	mb.players = n
+	if n > 0 {
+		mb.playersSeen = true
+	}
+}
+
+// recordBackup resets the player activity after a backup. Anyone still
+// online will be included in the next backup too.
+func (mb *mcbackup) recordBackup(when time.Time) {
+	mb.activityMu.Lock()
+	defer mb.activityMu.Unlock()
+	mb.lastBackup = when
+	mb.playersSeen = mb.players > 0
+}
+
+// idle determines whether a scheduled backup can be skipped, because no
+// players have been online since the last one
+func (mb *mcbackup) idle(when time.Time) bool {
+	mb.activityMu.Lock()
+	defer mb.activityMu.Unlock()
+
+	// Nothing is known about activity before mcbackup started
+	if mb.lastBackup.IsZero() || mb.playersSeen {
+		return false
+	}
+
+	interval := mb.opts.Cron.IdleInterval
+	return interval == 0 || when.Sub(mb.lastBackup) < interval
+}
+
+// pollPlayers checks who is online at each interval until the context is
+// cancelled, so that players who are only online between backups are seen.
+// Failures are left for the next backup to report.
+func (mb *mcbackup) pollPlayers(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		output, err := mb.command("list")
+		if err != nil {
+			mb.logger("rcon").
+				WithError(err).
+				Debug("failed to poll players online")
+			continue
+		}
+		mb.recordPlayers(output)
+	}
+}
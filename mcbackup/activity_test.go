@@ -0,0 +1,82 @@
+package mcbackup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spritsail/mcbackup/config"
+)
+
+func TestParsePlayerCount(t *testing.T) {
+	var tests = []struct {
+		output string
+		count  int
+		ok     bool
+	}{
+		{"There are 0 of a max of 20 players online: ", 0, true},
+		{"There are 2 of a max of 20 players online: Steve, Alex", 2, true},
+		{"There are 1/20 players online:Steve", 1, true},
+		{"Unknown command", 0, false},
+	}
+
+	for _, test := range tests {
+		count, ok := parsePlayerCount(test.output)
+		if count != test.count || ok != test.ok {
+			t.Errorf("parsePlayerCount(%q) -> %d, %v, should be %d, %v",
+				test.output, count, ok, test.count, test.ok)
+		}
+	}
+}
+
+func TestIdle(t *testing.T) {
+	const (
+		empty  = "There are 0 of a max of 20 players online: "
+		online = "There are 1 of a max of 20 players online: Steve"
+	)
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time {
+		return start.Add(time.Duration(minutes) * time.Minute)
+	}
+
+	opts := &config.Options{}
+	opts.Cron.IdleInterval = time.Hour
+	mb := New(nil, nil, opts)
+
+	if mb.idle(at(0)) {
+		t.Error("the first backup after starting was skipped")
+	}
+	mb.recordPlayers(empty)
+	mb.recordBackup(at(0))
+	if !mb.idle(at(15)) {
+		t.Error("a backup was taken with nobody online")
+	}
+
+	// A player who joins and leaves between backups is still backed up
+	mb.recordPlayers(online)
+	mb.recordPlayers(empty)
+	if mb.idle(at(30)) {
+		t.Error("a backup was skipped after a player was online")
+	}
+	mb.recordBackup(at(30))
+	if !mb.idle(at(45)) {
+		t.Error("a backup was taken with nobody online since the last")
+	}
+
+	// Players still online at a backup are included in the next
+	mb.recordPlayers(online)
+	mb.recordBackup(at(45))
+	if mb.idle(at(60)) {
+		t.Error("a backup was skipped with a player online")
+	}
+
+	// Idle backups are still taken once the interval has passed
+	mb.recordPlayers(empty)
+	mb.recordBackup(at(60))
+	if mb.idle(at(120)) {
+		t.Error("a backup was skipped after the idle interval")
+	}
+	opts.Cron.IdleInterval = 0
+	if !mb.idle(at(600)) {
+		t.Error("an idle backup was taken without an idle interval")
+	}
+}
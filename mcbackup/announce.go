@@ -0,0 +1,68 @@
+package mcbackup
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// announcement holds the values available to announcement templates
+type announcement struct {
+	Name    string
+	Size    string
+	Elapsed time.Duration
+	Error   error
+}
+
+// announce sends a message to all players on the server. Announcements are
+// only a courtesy, so any failure is logged rather than interrupting a backup.
+func (mb *mcbackup) announce(tmpl string, data announcement) {
+	if !mb.opts.Announce.Enabled || tmpl == "" || mb.rcon == nil {
+		return
+	}
+	log := mb.logger("announce")
+
+	msg, err := renderAnnouncement(tmpl, data)
+	if err != nil {
+		log.WithError(err).Warn("invalid announcement template")
+		return
+	}
+
+	var command string
+	switch mb.opts.Announce.Command {
+	case "say":
+		command = "say " + msg
+	default:
+		text, _ := json.Marshal(map[string]string{
+			"text":  "[mcbackup] " + msg,
+			"color": "gray",
+		})
+		command = "tellraw @a " + string(text)
+	}
+
+	output, err := mb.command(command)
+	if err != nil {
+		log.WithError(err).Warn("failed to send announcement")
+		return
+	}
+	if output != "" {
+		log.Debug(output)
+	}
+}
+
+func renderAnnouncement(tmpl string, data announcement) (string, error) {
+	t, err := template.New("announcement").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	err = t.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+	// Commands are a single line
+	return strings.Join(strings.Fields(buf.String()), " "), nil
+}
@@ -0,0 +1,302 @@
+package mcbackup
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spritsail/mcbackup/backup"
+	"github.com/spritsail/mcbackup/provider"
+)
+
+// API serves an HTTP API to control the backups of one or more servers,
+// sharing the servers with the cron loop
+type API struct {
+	token   string
+	servers map[string]*mcbackup
+
+	// Backups started through the API, which must finish before exiting
+	ctx  context.Context
+	jobs sync.WaitGroup
+}
+
+func NewAPI(token string) *API {
+	return &API{
+		token:   token,
+		servers: make(map[string]*mcbackup),
+	}
+}
+
+// Add makes a server controllable through the API. When several servers
+// are added, requests choose one by name with the server query parameter.
+func (api *API) Add(name string, mb *mcbackup) {
+	api.servers[name] = mb
+}
+
+// Serve handles API requests until the context is cancelled
+func (api *API) Serve(ctx context.Context, addr string) error {
+	log := logrus.WithField("prefix", "api")
+	if api.token == "" {
+		log.Warn("API token is not set, anyone able to connect can control backups")
+	}
+	log.Info("serving API at " + addr)
+
+	api.ctx = ctx
+	server := &http.Server{
+		Addr:    addr,
+		Handler: api.handler(),
+	}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	err := server.ListenAndServe()
+	api.jobs.Wait()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (api *API) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/servers", api.handleServers)
+	mux.HandleFunc("/api/backups", api.handleBackups)
+	mux.HandleFunc("/api/backups/", api.handleBackup)
+	mux.HandleFunc("/api/prune", api.handlePrune)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logrus.WithField("prefix", "api").
+			WithField("client", r.RemoteAddr).
+			Info(fmt.Sprintf("request %s %s", r.Method, r.URL.Path))
+
+		if !api.authorised(r) {
+			writeError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// authorised checks the bearer token of a request, if a token is required
+func (api *API) authorised(r *http.Request) bool {
+	if api.token == "" {
+		return true
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(api.token)) == 1
+}
+
+// server finds the server a request is for. The server can
+// only be left out of the request when there is just one.
+func (api *API) server(w http.ResponseWriter, r *http.Request) *mcbackup {
+	name := r.URL.Query().Get("server")
+	if name == "" && len(api.servers) == 1 {
+		for _, mb := range api.servers {
+			return mb
+		}
+	}
+
+	mb, ok := api.servers[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no server named '%s'", name))
+		return nil
+	}
+	return mb
+}
+
+func (api *API) handleServers(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	names := make([]string, 0, len(api.servers))
+	for name := range api.servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writeJSON(w, http.StatusOK, names)
+}
+
+// handleBackups lists backups, or starts a new backup
+func (api *API) handleBackups(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	mb := api.server(w, r)
+	if mb == nil {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		entries, err := mb.listEntries(mb.opts.Prune)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+		return
+	}
+
+	now := time.Now()
+	name, err := mb.opts.GenBackupName(now)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !mb.tryLockJob() {
+		writeError(w, http.StatusConflict, "another backup or prune is already running")
+		return
+	}
+
+	// Backups can take a long time, so run it in the background
+	api.jobs.Add(1)
+	go func() {
+		defer api.jobs.Done()
+		defer mb.unlockJob()
+
+		err := mb.TakeBackup(api.ctx, now)
+		if err != nil {
+			mb.logger("api").WithError(err).Error("requested backup failed")
+		}
+	}()
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"name": name})
+}
+
+// handleBackup serves the manifest or contents of a single backup
+func (api *API) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/backups/"), "/")
+	if len(parts) != 2 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	name, action := parts[0], parts[1]
+	if action != "manifest" && action != "download" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	mb := api.server(w, r)
+	if mb == nil {
+		return
+	}
+	bkup, err := mb.findBackup(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if action == "manifest" {
+		manifest, err := mb.prov.Manifest(bkup)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, manifest)
+		return
+	}
+
+	api.download(w, r, mb, bkup)
+}
+
+func (api *API) download(w http.ResponseWriter, r *http.Request, mb *mcbackup, bkup backup.Backup) {
+	opener, ok := mb.prov.(provider.Opener)
+	if !ok {
+		writeError(w, http.StatusNotImplemented,
+			fmt.Sprintf("%s backups can't be downloaded", mb.opts.Provider))
+		return
+	}
+
+	rc, filename, err := opener.Open(bkup)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+
+	// Allow resuming downloads where the backup can be seeked
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, filename, bkup.When(), rs)
+		return
+	}
+	_, err = io.Copy(w, rc)
+	if err != nil {
+		mb.logger("api").WithError(err).Warn("backup download interrupted")
+	}
+}
+
+// handlePrune prunes old backups, or just reports what would
+// be removed if the dry_run query parameter is set
+func (api *API) handlePrune(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	mb := api.server(w, r)
+	if mb == nil {
+		return
+	}
+
+	dryRun := mb.opts.DryRun
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "dry_run must be true or false")
+			return
+		}
+	}
+
+	if !mb.tryLockJob() {
+		writeError(w, http.StatusConflict, "another backup or prune is already running")
+		return
+	}
+	defer mb.unlockJob()
+
+	result, err := mb.prune(time.Now(), dryRun)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,94 @@
+package mcbackup
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spritsail/mcbackup/config"
+	"github.com/spritsail/mcbackup/provider"
+)
+
+func TestAPI(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "server")
+	err := os.MkdirAll(src, 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(src, "level.dat"), []byte("level"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &config.Options{BackupPrefix: "mcb-", BackupFormat: "%F-%H:%M"}
+	opts.Prune.KeepFor = time.Hour
+	prov, _, err := provider.NewTar([]string{"-s", src, "-b", filepath.Join(dir, "backups")}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = prov.Create(context.Background(), "mcb-2021-01-01-00:00", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := NewAPI("secret")
+	api.Add("", New(prov, nil, opts))
+	server := httptest.NewServer(api.handler())
+	defer server.Close()
+
+	request := func(method, path string, token string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := request("GET", "/api/backups", "wrong")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET /api/backups with a bad token -> %d, should be 401", resp.StatusCode)
+	}
+
+	var entries []listEntry
+	resp = request("GET", "/api/backups", "secret")
+	err = json.NewDecoder(resp.Body).Decode(&entries)
+	resp.Body.Close()
+	if err != nil || len(entries) != 1 || entries[0].Name != "mcb-2021-01-01-00:00" {
+		t.Errorf("GET /api/backups -> %v, %v", entries, err)
+	}
+
+	resp = request("GET", "/api/backups/mcb-2021-01-01-00:00/manifest", "secret")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET manifest -> %d, should be 200", resp.StatusCode)
+	}
+
+	resp = request("GET", "/api/backups/mcb-2021-01-01-00:00/download", "secret")
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(data) == 0 {
+		t.Errorf("GET download -> %d with %d bytes", resp.StatusCode, len(data))
+	}
+
+	var result pruneResult
+	resp = request("POST", "/api/prune?dry_run=true", "secret")
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if err != nil || !result.DryRun || len(result.Kept) != 1 {
+		t.Errorf("POST /api/prune?dry_run=true -> %+v, %v", result, err)
+	}
+}
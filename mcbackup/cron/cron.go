@@ -1,6 +1,7 @@
 package cron
 
 import (
+	"context"
 	"time"
 
 	"github.com/gorhill/cronexpr"
@@ -8,25 +9,30 @@ import (
 )
 
 type task struct {
-	Job        func(time.Time) error // function to run
-	When       *cronexpr.Expression  // when to run it
-	Done       chan error            // channel, called when the job is complete. if channel is closed, the job has already finished
-	ErrHandler func(error)           // optional function to handle errors, can be used to stop the timer
-	running    bool                  // set false
-	cancel     chan struct{}         // a channel to interrupt the sleeping loop
+	Job        func(context.Context, time.Time) error // function to run
+	When       *cronexpr.Expression                   // when to run it
+	Done       chan error                             // channel, called when the job is complete. if channel is closed, the job has already finished
+	ErrHandler func(error)                            // optional function to handle errors, can be used to stop the timer
+	running    bool                                   // set false
+	ctx        context.Context                        // cancelled to interrupt the sleeping loop and any running job
+	cancel     context.CancelFunc
 }
 
-func Schedule(when string, what func(time.Time) error) (*task, error) {
+func Schedule(when string, what func(context.Context, time.Time) error) (*task, error) {
 
 	whenExpr, err := cronexpr.Parse(when)
 	if err != nil {
 		return nil, err
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	return &task{
 		Job:     what,
 		When:    whenExpr,
+		Done:    make(chan error, 1),
 		running: false,
+		ctx:     ctx,
+		cancel:  cancel,
 	}, err
 }
 
@@ -38,8 +44,6 @@ func (t *task) Run() {
 	log := logrus.WithField("prefix", "cron")
 
 	t.running = true
-	t.Done = make(chan error, 1)
-	t.cancel = make(chan struct{}, 1)
 	defer close(t.Done)
 	var err error
 	for t.running {
@@ -65,13 +69,13 @@ func (t *task) Run() {
 			select {
 			case <-time.After(waitfor):
 				break
-			case <-t.cancel:
+			case <-t.ctx.Done():
 				t.running = false
 				return
 			}
 
 			log.Debug("executing job")
-			err = t.Job(next)
+			err = t.Job(t.ctx, next)
 
 			if err != nil {
 				log.WithError(err).
@@ -93,8 +97,10 @@ func (t *task) Run() {
 	t.Done <- err
 }
 
+// Cancel stops the task from running again, and cancels the
+// context passed to the job if it is currently running
 func (t *task) Cancel() {
 	t.running = false
-	// closing the channel should be enough to wake the loop
-	close(t.cancel)
+	// cancelling the context should be enough to wake the loop
+	t.cancel()
 }
@@ -0,0 +1,84 @@
+package mcbackup
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gorhill/cronexpr"
+	"github.com/spritsail/mcbackup/prometheus"
+)
+
+// ReadinessChecks reports whether the server can be reached and backed up,
+// and whether backups are being taken as often as they are scheduled. The
+// server can't be reached if its client couldn't be created at startup.
+func (mb *mcbackup) ReadinessChecks() []prometheus.HealthCheck {
+	name := mb.opts.ServerLabel()
+	return []prometheus.HealthCheck{
+		{Name: name + "/provider", Check: mb.prov.Check},
+		{Name: name + "/backup_age", Check: mb.checkBackupAge},
+		{
+			Name: name + "/rcon",
+			Check: func() error {
+				output, err := mb.command("list")
+				if err == nil {
+					mb.recordPlayers(output)
+				}
+				return err
+			},
+		},
+	}
+}
+
+// checkBackupAge fails if the newest backup is older than expected
+// from the cron schedule
+func (mb *mcbackup) checkBackupAge() error {
+	maxAge, err := mb.maxBackupAge()
+	if err != nil || maxAge == 0 {
+		return err
+	}
+
+	// Give the first backup a chance to be taken after starting
+	if time.Since(mb.started) < maxAge {
+		return nil
+	}
+
+	backups, err := mb.prov.List()
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		return fmt.Errorf("no backups taken since starting %s ago",
+			time.Since(mb.started).Round(time.Second))
+	}
+
+	sort.Sort(backups)
+	age := time.Since(backups[len(backups)-1].When())
+	if age > maxAge {
+		return fmt.Errorf("newest backup is %s old, should be less than %s",
+			age.Round(time.Second), maxAge)
+	}
+	return nil
+}
+
+// maxBackupAge allows a backup to be missed before the newest is considered
+// stale. Idle backups can be skipped for longer, or indefinitely.
+func (mb *mcbackup) maxBackupAge() (time.Duration, error) {
+	expr, err := cronexpr.Parse(mb.opts.Cron.CronSchedule)
+	if err != nil {
+		return 0, err
+	}
+	next := expr.NextN(time.Now(), 2)
+	if len(next) < 2 {
+		return 0, nil
+	}
+	interval := next[1].Sub(next[0])
+
+	if mb.opts.Cron.SkipIdle {
+		if mb.opts.Cron.IdleInterval == 0 {
+			return 0, nil
+		}
+		return mb.opts.Cron.IdleInterval + interval, nil
+	}
+	return 2 * interval, nil
+}
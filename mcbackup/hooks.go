@@ -0,0 +1,172 @@
+package mcbackup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spritsail/mcbackup/backup"
+)
+
+// Stages of a backup or prune that hook commands can be run at
+const (
+	hookPreSave    = "pre-save"
+	hookPreCreate  = "pre-create"
+	hookPostCreate = "post-create"
+	hookOnFailure  = "on-failure"
+	hookPostPrune  = "post-prune"
+)
+
+func (mb *mcbackup) hookCommand(stage string) string {
+	hooks := mb.opts.Hooks
+	switch stage {
+	case hookPreSave:
+		return hooks.PreSave
+	case hookPreCreate:
+		return hooks.PreCreate
+	case hookPostCreate:
+		return hooks.PostCreate
+	case hookOnFailure:
+		return hooks.OnFailure
+	case hookPostPrune:
+		return hooks.PostPrune
+	default:
+		return ""
+	}
+}
+
+// hook runs the command configured for a stage, if there is one, with
+// env added to its environment. Output from the command is logged.
+func (mb *mcbackup) hook(ctx context.Context, stage string, env []string) error {
+	command := mb.hookCommand(stage)
+	if command == "" {
+		return nil
+	}
+	log := mb.logger("hook").WithField("hook", stage)
+
+	if mb.opts.DryRun {
+		log.Infof("would run hook: %s", command)
+		return nil
+	}
+
+	env = append(env,
+		"MCBACKUP_HOOK="+stage,
+		"MCBACKUP_SERVER="+mb.opts.ServerName,
+		"MCBACKUP_PROVIDER="+mb.opts.Provider,
+		"MCBACKUP_SOURCE="+mb.prov.Source(),
+	)
+
+	log.Debugf("running hook: %s", command)
+	start := time.Now()
+	output, err := runHook(ctx, command, append(os.Environ(), env...), mb.opts.Hooks.Timeout)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line != "" {
+			log.Info(line)
+		}
+	}
+	if err != nil {
+		log.WithError(err).Warn("hook failed")
+		return fmt.Errorf("%s hook failed: %v", stage, err)
+	}
+
+	log.Debugf("hook finished in %s", time.Since(start))
+	return nil
+}
+
+// preHook runs a hook before a backup is taken, only returning an
+// error if a failing hook should abort the backup
+func (mb *mcbackup) preHook(ctx context.Context, stage string, name string) error {
+	err := mb.hook(ctx, stage, backupEnv(name, nil, nil))
+	if err != nil && mb.opts.Hooks.Abort {
+		return err
+	}
+	return nil
+}
+
+// backupEnv describes a backup to hooks. The backup is nil until
+// it has been created, and err is set if the backup failed.
+func backupEnv(name string, bkup backup.Backup, err error) []string {
+	env := []string{"MCBACKUP_BACKUP_NAME=" + name}
+
+	if bkup != nil {
+		if pather, ok := bkup.(backup.Pather); ok {
+			env = append(env, "MCBACKUP_BACKUP_PATH="+pather.Path())
+		}
+		if size, err := bkup.Size(); err == nil {
+			env = append(env, "MCBACKUP_BACKUP_SIZE="+strconv.FormatUint(size, 10))
+		}
+	}
+
+	return append(env, statusEnv(err)...)
+}
+
+// pruneEnv describes the result of a prune to hooks
+func pruneEnv(result *pruneResult, err error) []string {
+	var env []string
+	if result != nil {
+		env = append(env,
+			"MCBACKUP_PRUNE_KEPT="+strconv.Itoa(len(result.Kept)),
+			"MCBACKUP_PRUNE_REMOVED="+strconv.Itoa(len(result.Removed)),
+			"MCBACKUP_PRUNE_FAILED="+strconv.Itoa(len(result.Failed)),
+			"MCBACKUP_PRUNE_RECLAIMED="+strconv.FormatUint(result.Reclaimed, 10),
+		)
+		if err == nil && len(result.Failed) > 0 {
+			err = fmt.Errorf("failed to delete %d backups", len(result.Failed))
+		}
+	}
+	return append(env, statusEnv(err)...)
+}
+
+func statusEnv(err error) []string {
+	if err != nil {
+		return []string{"MCBACKUP_STATUS=failure", "MCBACKUP_ERROR=" + err.Error()}
+	}
+	return []string{"MCBACKUP_STATUS=success"}
+}
+
+// runHook runs a command with the shell, returning its combined output.
+// The command runs in its own process group so that, if it times out or
+// the context is cancelled, any processes it started are killed with it.
+func runHook(ctx context.Context, command string, env []string, timeout time.Duration) ([]byte, error) {
+	var output bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Env = env
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	err := cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case err = <-done:
+		return output.Bytes(), err
+	case <-expired:
+		err = fmt.Errorf("timed out after %s", timeout)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	<-done
+	return output.Bytes(), err
+}
@@ -0,0 +1,72 @@
+package mcbackup
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spritsail/mcbackup/config"
+	"github.com/spritsail/mcbackup/provider"
+)
+
+func TestHookEnv(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "server")
+	err := os.MkdirAll(src, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "hook.env")
+	opts := &config.Options{BackupPrefix: "mcb-", BackupFormat: "%F-%H:%M", Provider: "tar"}
+	opts.Hooks.PostCreate = "env > " + out
+	prov, _, err := provider.NewTar([]string{"-s", src, "-b", filepath.Join(dir, "backups")}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bkup, err := prov.Create(context.Background(), "mcb-2021-01-01-00:00", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mb := New(prov, nil, opts)
+	err = mb.hook(context.Background(), hookPostCreate, backupEnv(bkup.Name(), bkup, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := string(data)
+	for _, want := range []string{
+		"MCBACKUP_HOOK=post-create",
+		"MCBACKUP_BACKUP_NAME=mcb-2021-01-01-00:00",
+		"MCBACKUP_BACKUP_PATH=" + filepath.Join(dir, "backups"),
+		"MCBACKUP_BACKUP_SIZE=",
+		"MCBACKUP_STATUS=success",
+		"MCBACKUP_SOURCE=" + src,
+	} {
+		if !strings.Contains(env, want) {
+			t.Errorf("hook environment is missing %s", want)
+		}
+	}
+}
+
+func TestRunHookTimeout(t *testing.T) {
+	// The backgrounded sleep holds the output open after the shell is
+	// killed, so this only returns if the whole process group is killed
+	start := time.Now()
+	_, err := runHook(context.Background(), "sleep 10 & sleep 10", nil, 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("hook should have timed out")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("hook took %s to be killed", elapsed)
+	}
+}
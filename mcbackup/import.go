@@ -0,0 +1,39 @@
+package mcbackup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spritsail/mcbackup/provider"
+)
+
+// Import loads a backup that the provider exported, along with any backups
+// it depends upon, into a target outside of the server, such as another
+// dataset. The latest backup is imported if no name is given.
+func (mb *mcbackup) Import(ctx context.Context, name string, target string) error {
+	log := mb.logger("import")
+
+	importer, ok := mb.prov.(provider.Importer)
+	if !ok {
+		return fmt.Errorf("provider '%s' can't import backups", mb.opts.Provider)
+	}
+
+	what := "the latest backup"
+	if name != "" {
+		what = "backup " + name
+	}
+	if mb.opts.DryRun {
+		log.Infof("would import %s into %s", what, target)
+		return nil
+	}
+
+	log.Infof("importing %s into %s", what, target)
+	start := time.Now()
+	err := importer.Import(ctx, name, target, mb.opts.Import.Force)
+	if err != nil {
+		return err
+	}
+	log.Infof("imported %s in %s", what, time.Since(start).Round(time.Millisecond))
+	return nil
+}
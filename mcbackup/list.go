@@ -0,0 +1,114 @@
+package mcbackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spritsail/mcbackup/backup"
+	"github.com/spritsail/mcbackup/config"
+)
+
+type listEntry struct {
+	Name      string    `json:"name"`
+	When      time.Time `json:"timestamp"`
+	Size      *uint64   `json:"size"`
+	SpaceUsed *uint64   `json:"space_used"`
+	Reason    string    `json:"reason"`
+	Prune     bool      `json:"prune"`
+}
+
+// List writes out all backups, along with the reason each would be kept
+// or pruned, in the format chosen by the list command options
+func (mb *mcbackup) List(w io.Writer) error {
+	entries, err := mb.listEntries(mb.opts.List.Prune)
+	if err != nil {
+		return err
+	}
+
+	switch mb.opts.List.Output {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	default:
+		return writeTable(w, entries)
+	}
+}
+
+// listEntries describes all backups, oldest first, marking those
+// that would be removed by a prune with the given policy
+func (mb *mcbackup) listEntries(policy config.Prune) ([]listEntry, error) {
+	log := mb.logger("list")
+
+	backups, err := mb.prov.List()
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(backups)
+
+	// Work out the reason each backup would be kept. Anything that
+	// isn't kept would be removed by the next prune
+	_, remain, err := splitPrune(backups, policy)
+	if err != nil {
+		return nil, err
+	}
+	pruned := make(map[backup.Backup]bool, len(remain))
+	for _, bkup := range remain {
+		pruned[bkup] = true
+	}
+
+	entries := make([]listEntry, len(backups))
+	for i, bkup := range backups {
+		entry := listEntry{
+			Name:   bkup.Name(),
+			When:   bkup.When(),
+			Reason: bkup.Reason().String(),
+			Prune:  pruned[bkup],
+		}
+
+		size, err := bkup.Size()
+		if err != nil {
+			log.WithError(err).Warnf("failed to get size of backup %s", bkup.Name())
+		} else {
+			entry.Size = &size
+		}
+		used, err := bkup.SpaceUsed()
+		if err != nil {
+			log.WithError(err).Warnf("failed to get space used by backup %s", bkup.Name())
+		} else {
+			entry.SpaceUsed = &used
+		}
+
+		entries[i] = entry
+	}
+	return entries, nil
+}
+
+func writeTable(w io.Writer, entries []listEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTIMESTAMP\tSIZE\tON DISK\tRETENTION")
+
+	hBytes := func(n *uint64) string {
+		if n == nil {
+			return "?"
+		}
+		return humanize.Bytes(*n)
+	}
+
+	for _, entry := range entries {
+		reason := entry.Reason
+		if entry.Prune {
+			reason = "Prune"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", entry.Name,
+			entry.When.Format(time.RFC3339), hBytes(entry.Size),
+			hBytes(entry.SpaceUsed), reason)
+	}
+
+	return tw.Flush()
+}
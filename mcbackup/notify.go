@@ -0,0 +1,108 @@
+package mcbackup
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spritsail/mcbackup/backup"
+	"github.com/spritsail/mcbackup/notify"
+	"github.com/spritsail/mcbackup/provider"
+)
+
+// notifyBackup sends a notification once a backup has finished. The backup
+// is nil if it failed before being created, or if it was only a dry run.
+func (mb *mcbackup) notifyBackup(name string, bkup backup.Backup, elapsed time.Duration, err error) {
+	if err != nil {
+		mb.notifier.Send(notify.Event{
+			Kind:    notify.BackupFailed,
+			Message: fmt.Sprintf("Backup %s failed", name),
+			Error:   err.Error(),
+			Backup:  name,
+		})
+		return
+	}
+	if bkup == nil {
+		return
+	}
+
+	if mb.notifier.Enabled(notify.BackupSucceeded) {
+		event := notify.Event{
+			Kind:    notify.BackupSucceeded,
+			Message: fmt.Sprintf("Backup %s completed", name),
+			Fields:  []notify.Field{{Name: "Duration", Value: elapsed.Round(time.Second).String()}},
+			Backup:  name,
+		}
+		if size, err := bkup.Size(); err == nil {
+			event.Fields = append(event.Fields, notify.Field{Name: "Size", Value: humanize.Bytes(size)})
+			event.Size = size
+		}
+		mb.notifier.Send(event)
+	}
+
+	mb.checkCapacity()
+}
+
+// checkCapacity sends a notification if the storage holding
+// the backups is fuller than the configured threshold
+func (mb *mcbackup) checkCapacity() {
+	capacity, ok := mb.prov.(provider.Capacity)
+	if !ok || !mb.notifier.Enabled(notify.DiskFull) {
+		return
+	}
+
+	used, total, err := capacity.Capacity()
+	if err != nil {
+		mb.logger("notify").WithError(err).
+			Warn("failed to check backup storage capacity")
+		return
+	}
+	if total == 0 {
+		return
+	}
+
+	percent := used * 100 / total
+	if percent < uint64(mb.opts.Notify.DiskThreshold) {
+		return
+	}
+	mb.notifier.Send(notify.Event{
+		Kind:    notify.DiskFull,
+		Message: fmt.Sprintf("Backup storage is %d%% full", percent),
+		Fields: []notify.Field{
+			{Name: "Used", Value: humanize.Bytes(used)},
+			{Name: "Total", Value: humanize.Bytes(total)},
+		},
+	})
+}
+
+// notifyPrune sends a summary of a prune, if anything was removed or it failed
+func (mb *mcbackup) notifyPrune(result *pruneResult, err error) {
+	if err != nil {
+		mb.notifier.Send(notify.Event{
+			Kind:    notify.Pruned,
+			Message: "Pruning backups failed",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if len(result.Removed) == 0 && len(result.Failed) == 0 {
+		return
+	}
+
+	event := notify.Event{
+		Kind: notify.Pruned,
+		Message: fmt.Sprintf("Removed %d backups, reclaiming %s",
+			len(result.Removed), humanize.Bytes(result.Reclaimed)),
+		Fields: []notify.Field{
+			{Name: "Kept", Value: strconv.Itoa(len(result.Kept))},
+			{Name: "Removed", Value: strconv.Itoa(len(result.Removed))},
+		},
+		Removed:   len(result.Removed),
+		Reclaimed: result.Reclaimed,
+	}
+	if len(result.Failed) > 0 {
+		event.Error = fmt.Sprintf("failed to delete %d backups", len(result.Failed))
+	}
+	mb.notifier.Send(event)
+}
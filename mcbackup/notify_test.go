@@ -0,0 +1,88 @@
+package mcbackup
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/spritsail/mcbackup/config"
+	"github.com/spritsail/mcbackup/notify"
+)
+
+// digestRecorder records events the way the email digest does, and
+// keeps every notification it is sent
+type digestRecorder struct {
+	recorded []notify.Event
+	notified []notify.Event
+}
+
+func (dr *digestRecorder) Notify(ctx context.Context, event notify.Event) error {
+	dr.notified = append(dr.notified, event)
+	return nil
+}
+
+func (dr *digestRecorder) Record(event notify.Event) {
+	dr.recorded = append(dr.recorded, event)
+}
+
+func (dr *digestRecorder) String() string {
+	return "digest"
+}
+
+func TestRconFailureNotified(t *testing.T) {
+	opts := &config.Options{BackupPrefix: "mcb-", BackupFormat: "%F-%H:%M"}
+	opts.Notify.Events = []string{string(notify.BackupFailed)}
+
+	mb := New(nil, nil, opts)
+	recorder := &digestRecorder{}
+	mb.notifier.Add(recorder)
+
+	mb.rconFailed("mcb-2021-01-01-00:00", errors.New("connection refused"))
+
+	for what, events := range map[string][]notify.Event{
+		"recorded for the digest": recorder.recorded,
+		"notified":                recorder.notified,
+	} {
+		if len(events) != 1 {
+			t.Errorf("expected one event to be %s, got %d", what, len(events))
+			continue
+		}
+		event := events[0]
+		if event.Kind != notify.BackupFailed || event.Backup != "mcb-2021-01-01-00:00" || event.Error != "connection refused" {
+			t.Errorf("expected the failed backup to be %s, got %+v", what, event)
+		}
+	}
+}
+
+func TestDisconnectedBackupFails(t *testing.T) {
+	// Nothing is listening on the port once the listener is closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	opts := &config.Options{BackupPrefix: "mcb-", BackupFormat: "%F-%H:%M"}
+	opts.Host = "127.0.0.1"
+	opts.Port = uint(port)
+	opts.Notify.Events = []string{string(notify.BackupFailed)}
+
+	// A server whose client couldn't be created at startup tries to connect
+	// again before each backup, and reports the backup as failed
+	mb := New(nil, nil, opts)
+	recorder := &digestRecorder{}
+	mb.notifier.Add(recorder)
+
+	for i := 0; i < 2; i++ {
+		err = mb.TakeBackup(context.Background(), time.Date(2021, 1, 1, i, 0, 0, 0, time.UTC))
+		if err == nil {
+			t.Fatal("backup succeeded without a connection to the server")
+		}
+	}
+	if len(recorder.notified) != 2 {
+		t.Errorf("expected both backups to be reported as failed, got %d", len(recorder.notified))
+	}
+}
@@ -1,6 +1,8 @@
 package mcbackup
 
 import (
+	"context"
+	"fmt"
 	"sort"
 	"strings"
 	"time"
@@ -9,6 +11,8 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spritsail/mcbackup/backup"
 	"github.com/spritsail/mcbackup/config"
+	"github.com/spritsail/mcbackup/prometheus"
+	"github.com/spritsail/mcbackup/provider"
 )
 
 type PruneGroup struct {
@@ -18,24 +22,67 @@ type PruneGroup struct {
 	subTime func(time.Time, int) time.Time
 }
 
+// pruneResult lists the backups kept and removed by a prune
+type pruneResult struct {
+	DryRun    bool     `json:"dry_run"`
+	Kept      []string `json:"kept"`
+	Removed   []string `json:"removed"`
+	Failed    []string `json:"failed,omitempty"`
+	Reclaimed uint64   `json:"bytes_reclaimed"`
+}
+
 func (mb *mcbackup) Prune(from time.Time) error {
-	log := logrus.WithField("prefix", "prune")
+	_, err := mb.prune(from, mb.opts.DryRun)
+	return err
+}
+
+// prune removes old backups, running the post-prune hook and
+// sending a notification afterwards. Replicas are pruned by their
+// own policies after the main provider.
+func (mb *mcbackup) prune(from time.Time, dryRun bool) (*pruneResult, error) {
+	result, err := pruneBackups(mb.prov, mb.opts.Prune, mb.metrics, mb.logger("prune"), dryRun)
+	for _, r := range mb.replicas {
+		log := mb.logger("prune").WithField("replica", r.name)
+		_, e := pruneBackups(r.prov, r.opts.Prune, r.metrics, log, dryRun)
+		if e != nil {
+			log.WithError(e).Error("failed to prune replica")
+		}
+	}
 
-	backups, err := mb.prov.List()
+	if !dryRun {
+		mb.hook(context.Background(), hookPostPrune, pruneEnv(result, err))
+		mb.notifyPrune(result, err)
+	}
+	return result, err
+}
+
+func pruneBackups(prov provider.Provider, policy config.Prune, metrics *prometheus.Recorder, log *logrus.Entry, dryRun bool) (*pruneResult, error) {
+	result := &pruneResult{DryRun: dryRun}
+
+	backups, err := prov.List()
 	if err != nil {
-		return err
+		metrics.Stage(prometheus.StagePrune, err)
+		return nil, err
 	}
 
 	// Nothing to prune
 	if len(backups) < 1 {
 		log.Info("no backups to prune")
-		return nil
+		metrics.Stage(prometheus.StagePrune, nil)
+		return result, nil
 	}
 
 	// Ensure the backups are in a sorted order
 	sort.Sort(backups)
 
-	keep, remain, err := splitPrune(backups, mb.opts.Prune)
+	keep, remain, err := splitPrune(backups, policy)
+	if err != nil {
+		metrics.Stage(prometheus.StagePrune, err)
+		return nil, err
+	}
+	for _, bkup := range keep {
+		result.Kept = append(result.Kept, bkup.Name())
+	}
 
 	if len(keep) > 0 {
 		log.Infof("keeping %d backups", len(keep))
@@ -67,7 +114,7 @@ func (mb *mcbackup) Prune(from time.Time) error {
 	}
 	log.Infof("%s used by %d backups (%s total size)", humanize.Bytes(spaceKeep), len(keep), humanize.Bytes(sizeKeep))
 
-	if mb.opts.DryRun {
+	if dryRun {
 		log.Infof("actual prune would remove %d backups", len(remain))
 	} else {
 		log.Infof("removing %d backups", len(remain))
@@ -78,8 +125,11 @@ func (mb *mcbackup) Prune(from time.Time) error {
 	}
 
 	// Quick, return before we actually delete anything
-	if mb.opts.DryRun {
-		return nil
+	if dryRun {
+		for _, bkup := range remain {
+			result.Removed = append(result.Removed, bkup.Name())
+		}
+		return result, nil
 	}
 
 	var failed uint
@@ -104,20 +154,28 @@ func (mb *mcbackup) Prune(from time.Time) error {
 			log.WithError(err).
 				Warnf("failed to delete backup %s", bkup.Name())
 			failed++
+			result.Failed = append(result.Failed, bkup.Name())
 		} else {
 			spaceSaved += sizeOnDisk
 			sizeSaved += realSize
 			removed++
+			result.Removed = append(result.Removed, bkup.Name())
 		}
 	}
 	if failed > 0 {
 		log.Errorf("failed to delete %d backups", failed)
+		metrics.Stage(prometheus.StagePrune, fmt.Errorf("failed to delete %d backups", failed))
+	} else {
+		metrics.Stage(prometheus.StagePrune, nil)
 	}
+	metrics.Pruned(int(removed), spaceSaved)
+	metrics.Stored(spaceKeep)
 
 	log.Infof("%s saved in total with %d pruned backups (%s real size)", humanize.Bytes(spaceSaved),
 		removed, humanize.Bytes(sizeSaved))
 
-	return nil
+	result.Reclaimed = spaceSaved
+	return result, nil
 }
 
 func defaultPruneGroups(opts config.Prune) []PruneGroup {
@@ -281,3 +339,24 @@ func splitPrune(bs backup.Backups, opts config.Prune) (keep backup.Backups, rema
 
 	return
 }
+
+// recordStored records the space used by every backup a provider stores,
+// so that it is known without waiting for the next prune
+func recordStored(prov provider.Provider, metrics *prometheus.Recorder, log *logrus.Entry) {
+	backups, err := prov.List()
+	if err != nil {
+		log.WithError(err).Warn("failed to list backups to measure the space used")
+		return
+	}
+
+	var stored uint64
+	for _, bkup := range backups {
+		used, err := bkup.SpaceUsed()
+		if err != nil {
+			log.WithError(err).Warn("backup SpaceUsed failed")
+			continue
+		}
+		stored += used
+	}
+	metrics.Stored(stored)
+}
@@ -0,0 +1,89 @@
+package mcbackup
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/spritsail/mcbackup/backup"
+	"github.com/spritsail/mcbackup/config"
+	"github.com/spritsail/mcbackup/prometheus"
+	"github.com/spritsail/mcbackup/provider"
+)
+
+// replica is a provider that new backups are copied to,
+// which is pruned by its own policy
+type replica struct {
+	name    string
+	prov    provider.Replica
+	opts    *config.Options
+	metrics *prometheus.Recorder
+
+	// Time of the latest backup copied to the replica, if known
+	latest time.Time
+}
+
+// AddReplica copies each new backup to another provider, which is pruned
+// alongside the main provider by the policy in its options
+func (mb *mcbackup) AddReplica(prov provider.Replica, opts *config.Options) {
+	mb.replicas = append(mb.replicas, &replica{
+		name:    opts.Provider,
+		prov:    prov,
+		opts:    opts,
+		metrics: prometheus.NewRecorder(*opts),
+	})
+}
+
+// replicate copies a new backup to every replica. Failures are logged and
+// recorded in the metrics, but don't fail the backup, which is still kept
+// by the main provider.
+func (mb *mcbackup) replicate(ctx context.Context, bkup backup.Backup) {
+	for _, r := range mb.replicas {
+		log := mb.logger("replicate").WithField("replica", r.name)
+		log.Infof("copying backup %s", bkup.Name())
+
+		start := time.Now()
+		_, err := r.prov.Replicate(ctx, mb.prov, bkup)
+		if err != nil {
+			log.WithError(err).
+				Errorf("failed to copy backup %s", bkup.Name())
+			if r.latest.IsZero() {
+				r.latest = latestBackup(r.prov)
+			}
+		} else {
+			log.Infof("copied backup %s in %s", bkup.Name(),
+				time.Since(start).Round(time.Millisecond))
+			r.latest = bkup.When()
+			recordStored(r.prov, r.metrics, log)
+		}
+
+		mb.metrics.Replicated(r.name, err, r.latest, bkup.When().Sub(r.latest))
+	}
+}
+
+// export hands a new backup to a provider that copies it elsewhere itself.
+// As with replicas, failures are logged but don't fail the backup.
+func (mb *mcbackup) export(ctx context.Context, exporter provider.Exporter, bkup backup.Backup) {
+	log := mb.logger("export")
+
+	start := time.Now()
+	err := exporter.Export(ctx, bkup)
+	if err != nil {
+		log.WithError(err).
+			Errorf("failed to export backup %s", bkup.Name())
+		return
+	}
+	log.Debugf("exported backup %s in %s", bkup.Name(),
+		time.Since(start).Round(time.Millisecond))
+}
+
+// latestBackup finds the time of the latest backup held by a provider,
+// or zero if there are none or they can't be listed
+func latestBackup(prov provider.Provider) time.Time {
+	backups, err := prov.List()
+	if err != nil || len(backups) == 0 {
+		return time.Time{}
+	}
+	sort.Sort(backups)
+	return backups[len(backups)-1].When()
+}
@@ -0,0 +1,127 @@
+package mcbackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spritsail/mcbackup/backup"
+	"github.com/spritsail/mcbackup/provider"
+)
+
+// Restore rolls back to a previous backup, either into a separate target
+// directory or in-place over the live server. In-place restores take a
+// safety backup first and leave automatic saving disabled, as the server
+// must be restarted to load the restored world.
+func (mb *mcbackup) Restore(ctx context.Context, name string, target string) error {
+	log := mb.logger("restore")
+
+	bkup, err := mb.findBackup(name)
+	if err != nil {
+		return err
+	}
+
+	if target != "" {
+		err = checkRestoreTarget(target)
+		if err != nil {
+			return err
+		}
+
+		if mb.opts.DryRun {
+			log.Infof("would restore backup %s into %s", bkup.Name(), target)
+			return nil
+		}
+
+		log.Infof("restoring backup %s into %s", bkup.Name(), target)
+		start := time.Now()
+		err = mb.prov.Restore(bkup, target)
+		if err != nil {
+			return err
+		}
+		log.Infof("backup %s restored in %s", bkup.Name(), time.Since(start))
+		return nil
+	}
+
+	if mb.opts.DryRun {
+		log.Infof("would take a safety backup and restore backup %s in-place over %s",
+			bkup.Name(), mb.prov.Source())
+		return nil
+	}
+
+	log.Info("taking a safety backup before restoring")
+	err = mb.TakeBackup(ctx, time.Now())
+	if err != nil {
+		return fmt.Errorf("safety backup failed, not restoring: %v", err)
+	}
+
+	// Disable saving so the server doesn't write over the restored files
+	rlog := mb.logger("rcon")
+	output, err := mb.command("save-off")
+	if err != nil {
+		return err
+	}
+	rlog.Info(output)
+
+	log.Infof("restoring backup %s in-place over %s", bkup.Name(), mb.prov.Source())
+	start := time.Now()
+	err = mb.prov.Restore(bkup, "")
+
+	// Nothing has been restored over the live world, so it is safe to save
+	var unchanged *provider.UnchangedError
+	if errors.As(err, &unchanged) {
+		output, e := mb.command("save-on")
+		if e != nil {
+			rlog.WithError(e).Warn(output)
+		} else {
+			rlog.Info(output)
+		}
+		return err
+	}
+
+	// Re-enabling saving would overwrite the restored world with the one
+	// still loaded in the server, so it must be restarted instead
+	log.Warn("automatic saving has been left disabled, restart the server to load the restored world")
+	if err != nil {
+		return err
+	}
+
+	log.Infof("backup %s restored in %s", bkup.Name(), time.Since(start))
+	return nil
+}
+
+// findBackup looks up a backup from the provider by name
+func (mb *mcbackup) findBackup(name string) (backup.Backup, error) {
+	backups, err := mb.prov.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bkup := range backups {
+		if bkup.Name() == name {
+			return bkup, nil
+		}
+	}
+	return nil, fmt.Errorf("no backup found with name '%s'", name)
+}
+
+// checkRestoreTarget ensures that a restore won't overwrite existing files
+func checkRestoreTarget(target string) error {
+	dir, err := os.Open(target)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	_, err = dir.Readdirnames(1)
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return fmt.Errorf("refusing to restore into non-empty directory '%s'", target)
+}
@@ -1,9 +1,9 @@
 package mcbackup
 
 import (
-	"os"
-	"os/signal"
-	"syscall"
+	"context"
+	"errors"
+	"sync"
 	"time"
 
 	"github.com/SeerUK/minecraft-rcon/rcon"
@@ -12,6 +12,8 @@ import (
 	"github.com/spritsail/mcbackup/backup"
 	"github.com/spritsail/mcbackup/config"
 	"github.com/spritsail/mcbackup/mcbackup/cron"
+	"github.com/spritsail/mcbackup/notify"
+	"github.com/spritsail/mcbackup/prometheus"
 	"github.com/spritsail/mcbackup/provider"
 )
 
@@ -19,6 +21,26 @@ type mcbackup struct {
 	prov provider.Provider
 	rcon *rcon.Client
 	opts *config.Options
+
+	// Player activity, for skipping idle backups. Players are
+	// polled between backups, so activity is guarded
+	activityMu  sync.Mutex
+	lastBackup  time.Time
+	players     int
+	playersSeen bool
+
+	// Holds a value whilst a job is running, so that
+	// backups and prunes never run at the same time
+	jobs chan struct{}
+
+	// Guards the RCON client, which health checks
+	// may use whilst a backup is running
+	rconMu  sync.Mutex
+	started time.Time
+
+	metrics  *prometheus.Recorder
+	notifier *notify.Dispatcher
+	replicas []*replica
 }
 
 func New(p provider.Provider, rc *rcon.Client, opts *config.Options) *mcbackup {
@@ -26,11 +48,76 @@ func New(p provider.Provider, rc *rcon.Client, opts *config.Options) *mcbackup {
 	mb.prov = p
 	mb.rcon = rc
 	mb.opts = opts
+	mb.jobs = make(chan struct{}, 1)
+	mb.started = time.Now()
+	mb.metrics = prometheus.NewRecorder(*opts)
+	mb.notifier = notify.New(*opts)
 	return mb
 }
 
-func (mb *mcbackup) Cron() {
-	log := logrus.WithField("prefix", "cron")
+// command sends a command to the server over RCON
+func (mb *mcbackup) command(cmd string) (string, error) {
+	mb.rconMu.Lock()
+	defer mb.rconMu.Unlock()
+	if mb.rcon == nil {
+		return "", errors.New("not connected to the server over rcon")
+	}
+	return mb.rcon.SendCommand(cmd)
+}
+
+// reconnect connects to the server again, creating the client if it
+// couldn't be created when mcbackup started
+func (mb *mcbackup) reconnect() error {
+	mb.rconMu.Lock()
+	defer mb.rconMu.Unlock()
+	if mb.rcon == nil {
+		client, err := NewClient(mb.opts)
+		if err != nil {
+			return err
+		}
+		mb.rcon = client
+		return nil
+	}
+	return mb.rcon.Reconnect()
+}
+
+// lockJob waits for any running job to finish before starting another
+func (mb *mcbackup) lockJob(ctx context.Context) error {
+	select {
+	case mb.jobs <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tryLockJob starts a job only if no other job is running
+func (mb *mcbackup) tryLockJob() bool {
+	select {
+	case mb.jobs <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (mb *mcbackup) unlockJob() {
+	<-mb.jobs
+}
+
+// logger creates a log entry with the given prefix, naming
+// the server when there is more than one
+func (mb *mcbackup) logger(prefix string) *logrus.Entry {
+	log := logrus.WithField("prefix", prefix)
+	if mb.opts.ServerName != "" {
+		log = log.WithField("server", mb.opts.ServerName)
+	}
+	return log
+}
+
+// Cron takes backups on a schedule until the context is cancelled
+func (mb *mcbackup) Cron(ctx context.Context) {
+	log := mb.logger("cron")
 	log.Info("starting cron")
 
 	job, err := cron.Schedule(mb.opts.Cron.CronSchedule, mb.cronRunner)
@@ -39,17 +126,34 @@ func (mb *mcbackup) Cron() {
 			Fatal("failed to schedule backup job")
 	}
 
+	// Space used is otherwise only known once a backup is taken or pruned
+	recordStored(mb.prov, mb.metrics, log)
+	for _, r := range mb.replicas {
+		recordStored(r.prov, r.metrics, log.WithField("replica", r.name))
+	}
+
 	go job.Run()
 
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	// Players who join and leave between backups are only seen by polling
+	if mb.opts.Cron.SkipIdle && mb.opts.Cron.PollInterval > 0 {
+		go mb.pollPlayers(ctx, mb.opts.Cron.PollInterval)
+	}
+
+	// Scheduled notifications, such as digests, are sent alongside backups
+	notifyCtx, cancel := context.WithCancel(ctx)
+	var notifying sync.WaitGroup
+	notifying.Add(1)
+	go func() {
+		defer notifying.Done()
+		mb.notifier.Run(notifyCtx)
+	}()
+	defer notifying.Wait()
+	defer cancel()
 
 	select {
-	case sig := <-sigs:
-		// Wait for a signal
-		log.WithField("signal", sig).Info("caught signal")
-		// Stop the repeated task and then wait for it to complete (below)
-		log.Info("waiting for backup to complete")
+	case <-ctx.Done():
+		// Stop the repeated task and then wait for it to finish (below)
+		log.Info("cancelling any running backup")
 		job.Cancel()
 
 		// Now wait for job to terminate
@@ -67,69 +171,121 @@ func (mb *mcbackup) Cron() {
 		break
 	}
 }
-func (mb *mcbackup) cronRunner(t time.Time) error {
-	err := mb.TakeBackup(t)
+func (mb *mcbackup) cronRunner(ctx context.Context, t time.Time) error {
+	err := mb.lockJob(ctx)
+	if err != nil {
+		return err
+	}
+	defer mb.unlockJob()
+
+	if mb.opts.Cron.SkipIdle {
+		err := mb.checkClient()
+		if err != nil {
+			name, e := mb.opts.GenBackupName(t)
+			if e != nil {
+				return e
+			}
+			mb.rconFailed(name, err)
+			return err
+		}
+		if mb.idle(t) {
+			mb.logger("cron").
+				Infof("skipping backup, no players online since %s", mb.lastBackup.Format(time.Stamp))
+			return nil
+		}
+	}
+
+	err = mb.TakeBackup(ctx, t)
 	if err != nil {
 		return err
 	}
 
+	if mb.opts.Cron.Verify && !mb.opts.DryRun {
+		name, err := mb.opts.GenBackupName(t)
+		if err != nil {
+			return err
+		}
+		err = mb.Verify(name, false)
+		if err != nil {
+			return err
+		}
+	}
+
 	if !mb.opts.Cron.NoPrune {
 		return mb.Prune(t)
 	}
 	return nil
 }
 
-func (mb *mcbackup) TakeBackup(when time.Time) (err error) {
-	log := logrus.WithField("prefix", "rcon")
+func (mb *mcbackup) TakeBackup(ctx context.Context, when time.Time) (err error) {
+	log := mb.logger("rcon")
 
 	backupName, err := mb.opts.GenBackupName(when)
 	if err != nil {
 		return err
 	}
 
-	// Send a test command to check the client works
-	_, err = mb.rcon.SendCommand("list")
+	err = mb.checkClient()
 	if err != nil {
-		log.Error("error communicating with rcon, reconnecting")
-
-		// Try reconnecting
-		err = mb.rcon.Reconnect()
+		mb.rconFailed(backupName, err)
+		return
+	}
 
-		// Only return error if reconnecting fails
-		if err != nil {
-			return
-		}
+	err = mb.preHook(ctx, hookPreSave, backupName)
+	if err != nil {
+		mb.hook(context.Background(), hookOnFailure, backupEnv(backupName, nil, err))
+		mb.notifyBackup(backupName, nil, 0, err)
+		return
 	}
 
 	log.Info("starting backup")
+	started := time.Now()
+	mb.announce(mb.opts.Announce.Start, announcement{Name: backupName})
 
 	// Disable automatic saving
-	output, err := mb.rcon.SendCommand("save-off")
+	var bkup backup.Backup
+	output, err := mb.command("save-off")
 	log.Info(output)
+	rconErr := err
 	if err == nil {
 
-		// Manually save before taking backup
-		output, err = mb.rcon.SendCommand("save-all")
-		log.Info(output)
+		// Manually save before taking backup, waiting until it completes
+		err = mb.saveGame(ctx)
+		mb.metrics.Stage(prometheus.StageSave, err)
 		if err != nil {
 			log.WithError(err).
 				Warn("saving failed, attempting to re-enable saving")
 		} else {
-			if !mb.opts.DryRun {
+			err = mb.preHook(ctx, hookPreCreate, backupName)
+			if err != nil {
+				log.WithError(err).
+					Warn("aborting backup, attempting to re-enable saving")
+			} else if !mb.opts.DryRun {
 
 				// Take a backup if saving succeeded
-				var bkup backup.Backup
 				start := time.Now()
-				bkup, err = mb.prov.Create(backupName, when)
+				bkup, err = mb.prov.Create(ctx, backupName, when)
 				elapsed := time.Since(start)
+				mb.metrics.Stage(prometheus.StageCreate, err)
 
 				if err == nil {
-					logBackupStats(bkup, elapsed)
+					mb.recordBackup(when)
+
+					hSize := "?"
+					size, used, e := logBackupStats(mb.logger("backup"), bkup, elapsed)
+					if e == nil || size > 0 {
+						hSize = humanize.Bytes(size)
+					}
+					mb.metrics.Backup(elapsed, size, used)
+					mb.announce(mb.opts.Announce.Finish, announcement{
+						Name:    backupName,
+						Size:    hSize,
+						Elapsed: elapsed.Round(time.Second),
+					})
 				} else {
 					// Log the error but don't return to re-enable saving.
 					// Saving shouldn't ever be left disabled
-					logrus.
-						WithField("prefix", "backup").
+					mb.logger("backup").
 						WithError(err).
 						Error("failed to take backup")
 				}
@@ -137,19 +293,93 @@ func (mb *mcbackup) TakeBackup(when time.Time) (err error) {
 		}
 	}
 
+	if err != nil {
+		mb.announce(mb.opts.Announce.Failure, announcement{
+			Name:    backupName,
+			Elapsed: time.Since(started).Round(time.Second),
+			Error:   err,
+		})
+	}
+
 	// Always re-enable automatic saving before returning
-	output, e := mb.rcon.SendCommand("save-on")
+	output, e := mb.command("save-on")
+	if rconErr == nil {
+		rconErr = e
+	}
+	mb.metrics.Stage(prometheus.StageRcon, rconErr)
 	if e != nil {
 		log.WithError(e).Warn(output)
-		return e
+	} else {
+		log.Info(output)
 	}
-	log.Info(output)
 
+	// Copying the backup elsewhere may be slow, so it waits until saving is
+	// re-enabled too
+	if bkup != nil && len(mb.replicas) > 0 {
+		mb.replicate(ctx, bkup)
+	}
+	if exporter, ok := mb.prov.(provider.Exporter); ok && bkup != nil {
+		mb.export(ctx, exporter, bkup)
+	}
+	if bkup != nil {
+		recordStored(mb.prov, mb.metrics, mb.logger("backup"))
+	}
+
+	// Post hooks run once saving is re-enabled so they can't hold it up, and
+	// aren't cancelled with the backup so that failures are still reported
+	if err == nil {
+		err = e
+	}
+	if err != nil {
+		mb.hook(context.Background(), hookOnFailure, backupEnv(backupName, bkup, err))
+	} else {
+		mb.hook(context.Background(), hookPostCreate, backupEnv(backupName, bkup, nil))
+	}
+	mb.notifyBackup(backupName, bkup, time.Since(started), err)
+
+	if e != nil {
+		return e
+	}
 	return
 }
 
-func logBackupStats(bkup backup.Backup, elapsed time.Duration) error {
-	log := logrus.WithField("prefix", "backup")
+// rconFailed reports a backup that couldn't start because
+// the server couldn't be reached over RCON
+func (mb *mcbackup) rconFailed(name string, err error) {
+	mb.logger("rcon").
+		WithError(err).
+		Error("failed to reach server, not taking backup")
+	mb.metrics.Stage(prometheus.StageRcon, err)
+	mb.hook(context.Background(), hookOnFailure, backupEnv(name, nil, err))
+	mb.notifyBackup(name, nil, 0, err)
+}
+
+// checkClient sends a test command to check the client works, reconnecting
+// if not. The player list it returns is used to track player activity.
+func (mb *mcbackup) checkClient() error {
+	output, err := mb.command("list")
+	if err != nil {
+		mb.logger("rcon").
+			Error("error communicating with rcon, reconnecting")
+
+		// Try reconnecting. Only return error if reconnecting fails
+		err = mb.reconnect()
+		if err != nil {
+			return err
+		}
+		output, err = mb.command("list")
+		if err != nil {
+			return err
+		}
+	}
+
+	mb.recordPlayers(output)
+	return nil
+}
+
+// logBackupStats logs the size of a new backup, returning
+// its size and the space it uses on disk
+func logBackupStats(log *logrus.Entry, bkup backup.Backup, elapsed time.Duration) (uint64, uint64, error) {
 	var hSize, hUsed = "?", "?"
 
 	// Log size/disk space used
@@ -161,7 +391,7 @@ func logBackupStats(bkup backup.Backup, elapsed time.Duration) error {
 			Warnf("failed to get size of backup")
 		log.Infof("backup %s created in %s",
 			bkup.Name(), elapsed)
-		return err
+		return 0, 0, err
 	}
 
 	used, err := bkup.SpaceUsed()
@@ -172,7 +402,7 @@ func logBackupStats(bkup backup.Backup, elapsed time.Duration) error {
 			Warnf("failed to get space used by backup")
 		log.Infof("backup %s created in %s, %s size",
 			bkup.Name(), elapsed, hSize)
-		return err
+		return size, 0, err
 	}
 
 	mbps := float64(used) / elapsed.Seconds()
@@ -186,7 +416,7 @@ func logBackupStats(bkup backup.Backup, elapsed time.Duration) error {
 			bkup.Name(), elapsed, hSize, hSize, hUsed)
 	}
 
-	return nil
+	return size, used, nil
 }
 
 func NewClient(opts *config.Options) (*rcon.Client, error) {
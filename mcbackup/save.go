@@ -0,0 +1,119 @@
+package mcbackup
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// savedMessage is printed by the server once the world has been saved
+const savedMessage = "Saved the game"
+
+// saveGame asks the server to save the world, then waits for the save to
+// complete. Confirmation is taken from the RCON response where the server
+// includes it, otherwise from the server log.
+func (mb *mcbackup) saveGame(ctx context.Context) error {
+	log := mb.logger("rcon")
+
+	// Start watching the log before saving so the message can't be missed
+	serverLog, err := mb.openServerLog()
+	if err != nil {
+		log.WithError(err).
+			Warn("unable to watch server log for save completion")
+	}
+	if serverLog != nil {
+		defer serverLog.Close()
+	}
+
+	command := "save-all"
+	if mb.opts.SaveFlush {
+		command += " flush"
+	}
+	output, err := mb.command(command)
+	log.Info(output)
+	if err != nil {
+		return err
+	}
+
+	if strings.Contains(output, savedMessage) {
+		return nil
+	}
+	if serverLog == nil {
+		log.Warn("unable to confirm that saving has completed")
+		return nil
+	}
+
+	log.WithField("timeout", mb.opts.SaveTimeout).
+		Debug("waiting for the server to finish saving")
+
+	waitCtx, cancel := context.WithTimeout(ctx, mb.opts.SaveTimeout)
+	defer cancel()
+	err = waitForLine(waitCtx, serverLog, savedMessage)
+	if err == context.DeadlineExceeded {
+		return fmt.Errorf("server did not finish saving within %s", mb.opts.SaveTimeout)
+	}
+	return err
+}
+
+// openServerLog opens the server log, positioned at the end so that only
+// new messages are read. The default log is optional, so if it doesn't
+// exist then nil is returned with no error.
+func (mb *mcbackup) openServerLog() (*os.File, error) {
+	logPath := mb.opts.ServerLog
+	if logPath == "" {
+		if mb.prov.Source() == "" {
+			return nil, nil
+		}
+		logPath = filepath.Join(mb.prov.Source(), "logs", "latest.log")
+		if _, err := os.Stat(logPath); os.IsNotExist(err) {
+			return nil, nil
+		}
+	}
+
+	file, err := os.Open(logPath)
+	if err != nil {
+		return nil, err
+	}
+	_, err = file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// waitForLine follows a file as it is written, until a line containing
+// msg appears or the context is done
+func waitForLine(ctx context.Context, file io.Reader, msg string) error {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	r := bufio.NewReader(file)
+	var line string
+	for {
+		chunk, err := r.ReadString('\n')
+		line += chunk
+		if err == nil {
+			if strings.Contains(line, msg) {
+				return nil
+			}
+			line = ""
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+
+		// Wait for more to be written
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
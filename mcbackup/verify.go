@@ -0,0 +1,79 @@
+package mcbackup
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spritsail/mcbackup/backup"
+	"github.com/spritsail/mcbackup/prometheus"
+)
+
+// Verify checks backups against the manifests written when they were taken.
+// If all is set every backup is checked, otherwise only the named backup,
+// or the latest backup if no name is given.
+func (mb *mcbackup) Verify(name string, all bool) error {
+	log := mb.logger("verify")
+
+	var backups backup.Backups
+	var err error
+	switch {
+	case all:
+		backups, err = mb.prov.List()
+		if err != nil {
+			return err
+		}
+		sort.Sort(backups)
+
+	case name != "":
+		bkup, err := mb.findBackup(name)
+		if err != nil {
+			return err
+		}
+		backups = backup.Backups{bkup}
+
+	default:
+		backups, err = mb.prov.List()
+		if err != nil {
+			return err
+		}
+		if len(backups) == 0 {
+			return errors.New("no backups found to verify")
+		}
+		sort.Sort(backups)
+		backups = backups[len(backups)-1:]
+	}
+
+	var corrupt int
+	for _, bkup := range backups {
+		log := log.WithField("backup", bkup.Name())
+		log.Info("verifying backup")
+
+		start := time.Now()
+		err = mb.prov.Verify(bkup)
+		if err == nil {
+			log.Infof("backup verified in %s", time.Since(start).Round(time.Millisecond))
+			continue
+		}
+
+		corrupt++
+		var verr *backup.VerifyError
+		if errors.As(err, &verr) {
+			for _, problem := range verr.Problems {
+				log.Warn(problem)
+			}
+			log.Errorf("backup is corrupt, %d problems found", len(verr.Problems))
+		} else {
+			log.WithError(err).Error("failed to verify backup")
+		}
+	}
+
+	prometheus.RecordVerify(mb.opts.ServerLabel(), time.Now(), len(backups), corrupt)
+
+	if corrupt > 0 {
+		return fmt.Errorf("%d of %d backups failed verification", corrupt, len(backups))
+	}
+	log.Infof("%d backups verified", len(backups))
+	return nil
+}
@@ -0,0 +1,268 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/sirupsen/logrus"
+	"github.com/spritsail/mcbackup/config"
+	"github.com/spritsail/mcbackup/mcbackup/cron"
+)
+
+// Email sends notifications through an SMTP server, along with
+// a regular digest of every backup and prune since the last one
+type Email struct {
+	host     string
+	port     uint
+	security string
+	username string
+	password string
+	from     string
+	to       []string
+	digest   string
+
+	// Events recorded since the last digest was sent
+	mu     sync.Mutex
+	events []Event
+	since  time.Time
+}
+
+func NewEmail(opts config.Notify) (*Email, error) {
+	if opts.SMTPFrom == "" {
+		return nil, fmt.Errorf("an address to send emails from is required")
+	}
+	if len(opts.SMTPTo) == 0 {
+		return nil, fmt.Errorf("at least one address to send emails to is required")
+	}
+
+	return &Email{
+		host:     opts.SMTPHost,
+		port:     opts.SMTPPort,
+		security: opts.SMTPSecurity,
+		username: opts.SMTPUsername,
+		password: opts.SMTPPassword,
+		from:     opts.SMTPFrom,
+		to:       opts.SMTPTo,
+		digest:   opts.SMTPDigest,
+		since:    time.Now(),
+	}, nil
+}
+
+func (e *Email) String() string {
+	return "email via " + net.JoinHostPort(e.host, strconv.Itoa(int(e.port)))
+}
+
+// Notify emails a single event
+func (e *Email) Notify(ctx context.Context, event Event) error {
+	var body strings.Builder
+	body.WriteString(event.Summary() + "\n\n")
+	for _, field := range event.allFields() {
+		fmt.Fprintf(&body, "%s: %s\n", field.Name, field.Value)
+	}
+	fmt.Fprintf(&body, "Time: %s\n", event.Time.Format(time.RFC1123))
+
+	subject := fmt.Sprintf("[%s] %s", event.Server, event.Kind.Title())
+	return e.send(ctx, subject, body.String())
+}
+
+// Record keeps an event for the next digest
+func (e *Email) Record(event Event) {
+	if e.digest == "" {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = append(e.events, event)
+}
+
+// Run sends a digest on the configured schedule, until the context is cancelled
+func (e *Email) Run(ctx context.Context) {
+	if e.digest == "" {
+		return
+	}
+	log := logrus.WithField("prefix", "notify")
+
+	task, err := cron.Schedule(e.digest, e.sendDigest)
+	if err != nil {
+		log.WithError(err).
+			Error("invalid email digest schedule, not sending digests")
+		return
+	}
+	go task.Run()
+
+	<-ctx.Done()
+	task.Cancel()
+	<-task.Done
+}
+
+func (e *Email) sendDigest(ctx context.Context, when time.Time) error {
+	e.mu.Lock()
+	events, since := e.events, e.since
+	e.events, e.since = nil, when
+	e.mu.Unlock()
+
+	// Don't let an unresponsive mail server hold up the next digest
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	subject, body := digest(events, since, when)
+	err := e.send(ctx, subject, body)
+	if err != nil {
+		// Keep the events for the next digest
+		e.mu.Lock()
+		e.events, e.since = append(events, e.events...), since
+		e.mu.Unlock()
+	}
+	return err
+}
+
+// digest summarises the events between two times
+func digest(events []Event, since, until time.Time) (subject string, body string) {
+	var server string
+	var taken, failed, removed int
+	var size, reclaimed uint64
+	for _, event := range events {
+		server = event.Server
+		switch event.Kind {
+		case BackupSucceeded:
+			taken++
+			size += event.Size
+		case BackupFailed:
+			failed++
+		case Pruned:
+			removed += event.Removed
+			reclaimed += event.Reclaimed
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Backups from %s to %s\n\n",
+		since.Format(time.RFC1123), until.Format(time.RFC1123))
+	fmt.Fprintf(&b, "Backups taken: %d (%s in total)\n", taken, humanize.Bytes(size))
+	fmt.Fprintf(&b, "Backups failed: %d\n", failed)
+	fmt.Fprintf(&b, "Backups pruned: %d (%s reclaimed)\n", removed, humanize.Bytes(reclaimed))
+
+	if len(events) == 0 {
+		b.WriteString("\nNo backups were taken or pruned.\n")
+	} else {
+		b.WriteString("\n")
+		for _, event := range events {
+			fmt.Fprintf(&b, "%s  %-18s %s", event.Time.Format("2006-01-02 15:04"),
+				event.Kind, event.Message)
+			if event.Error != "" {
+				fmt.Fprintf(&b, ": %s", event.Error)
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	subject = "Backup digest"
+	if server != "" {
+		subject = fmt.Sprintf("[%s] %s", server, subject)
+	}
+	if failed > 0 {
+		subject += fmt.Sprintf(", %d failed", failed)
+	}
+	return subject, b.String()
+}
+
+// send delivers an email to every recipient
+func (e *Email) send(ctx context.Context, subject string, body string) error {
+	addr := net.JoinHostPort(e.host, strconv.Itoa(int(e.port)))
+	tlsConfig := &tls.Config{ServerName: e.host}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if e.security == "tls" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if e.security == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s doesn't support STARTTLS", addr)
+		}
+		err = client.StartTLS(tlsConfig)
+		if err != nil {
+			return err
+		}
+	}
+	if e.username != "" {
+		err = client.Auth(smtp.PlainAuth("", e.username, e.password, e.host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(e.from)
+	if err != nil {
+		return err
+	}
+	for _, to := range e.to {
+		err = client.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(e.message(subject, body))
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (e *Email) message(subject string, body string) []byte {
+	var msg bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&msg, "%s: %s\r\n", name, value)
+	}
+	header("From", e.from)
+	header("To", strings.Join(e.to, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	msg.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&msg)
+	qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	qp.Close()
+	return msg.Bytes()
+}
+
+var _ Notifier = &Email{}
+var _ Recorder = &Email{}
+var _ Runner = &Email{}
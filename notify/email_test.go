@@ -0,0 +1,163 @@
+package notify
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"mime/quotedprintable"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spritsail/mcbackup/config"
+)
+
+// smtpSink is a minimal SMTP server that accepts any email
+type smtpSink struct {
+	listener net.Listener
+	auth     string
+	messages chan string
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &smtpSink{listener: listener, messages: make(chan string, 10)}
+	go sink.serve()
+	return sink
+}
+
+func (sink *smtpSink) port() uint {
+	return uint(sink.listener.Addr().(*net.TCPAddr).Port)
+}
+
+func (sink *smtpSink) serve() {
+	for {
+		conn, err := sink.listener.Accept()
+		if err != nil {
+			return
+		}
+		go sink.handle(textproto.NewConn(conn))
+	}
+}
+
+func (sink *smtpSink) handle(conn *textproto.Conn) {
+	defer conn.Close()
+	conn.PrintfLine("220 localhost ESMTP sink")
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.Fields(line + " ")[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			conn.PrintfLine("250-localhost")
+			conn.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			creds, _ := base64.StdEncoding.DecodeString(strings.Fields(line)[2])
+			sink.auth = string(creds)
+			conn.PrintfLine("235 authenticated")
+		case "DATA":
+			conn.PrintfLine("354 go ahead")
+			data, err := conn.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			sink.messages <- string(data)
+			conn.PrintfLine("250 queued")
+		case "QUIT":
+			conn.PrintfLine("221 bye")
+			return
+		default:
+			conn.PrintfLine("250 ok")
+		}
+	}
+}
+
+func (sink *smtpSink) message(t *testing.T) (header string, body string) {
+	select {
+	case msg := <-sink.messages:
+		parts := strings.SplitN(msg, "\n\n", 2)
+		decoded, err := ioutil.ReadAll(quotedprintable.NewReader(strings.NewReader(parts[1])))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parts[0], string(decoded)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email received")
+		return
+	}
+}
+
+func TestEmail(t *testing.T) {
+	sink := newSMTPSink(t)
+	defer sink.listener.Close()
+
+	email, err := NewEmail(config.Notify{
+		SMTPHost:     "127.0.0.1",
+		SMTPPort:     sink.port(),
+		SMTPSecurity: "none",
+		SMTPUsername: "mcbackup",
+		SMTPPassword: "hunter2",
+		SMTPFrom:     "mcbackup@example.com",
+		SMTPTo:       []string{"ops@example.com"},
+		SMTPDigest:   "0 8 * * *",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := Event{
+		Kind:    BackupFailed,
+		Server:  "survival",
+		Time:    time.Now(),
+		Message: "Backup mcb-2021-01-01-00:00 failed",
+		Error:   "disk full",
+	}
+	err = email.Notify(context.Background(), failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, body := sink.message(t)
+	if !strings.Contains(header, "Subject: [survival] Backup failed") {
+		t.Errorf("unexpected headers:\n%s", header)
+	}
+	if !strings.Contains(body, "Error: disk full") {
+		t.Errorf("unexpected body:\n%s", body)
+	}
+	if sink.auth != "\x00mcbackup\x00hunter2" {
+		t.Errorf("unexpected credentials %q", sink.auth)
+	}
+
+	email.Record(failed)
+	email.Record(Event{Kind: BackupSucceeded, Server: "survival", Size: 1000000})
+	email.Record(Event{Kind: Pruned, Server: "survival", Removed: 3, Reclaimed: 5000000})
+	err = email.sendDigest(context.Background(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, body = sink.message(t)
+	if !strings.Contains(header, "Subject: [survival] Backup digest, 1 failed") {
+		t.Errorf("unexpected digest headers:\n%s", header)
+	}
+	for _, want := range []string{
+		"Backups taken: 1 (1.0 MB in total)",
+		"Backups failed: 1",
+		"Backups pruned: 3 (5.0 MB reclaimed)",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("digest is missing %s:\n%s", strconv.Quote(want), body)
+		}
+	}
+	if len(email.events) != 0 {
+		t.Errorf("events should be cleared after sending a digest")
+	}
+}
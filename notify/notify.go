@@ -0,0 +1,237 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spritsail/mcbackup/config"
+)
+
+// Kind is the type of event a notification is sent for
+type Kind string
+
+// Events that notifications can be sent for, named the same as the
+// values of the --notify option
+const (
+	BackupSucceeded Kind = "backup-succeeded"
+	BackupFailed    Kind = "backup-failed"
+	Pruned          Kind = "prune"
+	DiskFull        Kind = "disk-full"
+)
+
+// Title summarises the kind of event in a few words
+func (kind Kind) Title() string {
+	switch kind {
+	case BackupSucceeded:
+		return "Backup succeeded"
+	case BackupFailed:
+		return "Backup failed"
+	case Pruned:
+		return "Backups pruned"
+	case DiskFull:
+		return "Backup storage nearly full"
+	default:
+		return string(kind)
+	}
+}
+
+// Event is something that happened to the backups of a server
+type Event struct {
+	Kind    Kind      `json:"event"`
+	Server  string    `json:"server"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+	Error   string    `json:"error,omitempty"`
+	Fields  []Field   `json:"fields,omitempty"`
+
+	// Details of the backup or prune, for summarising events
+	Backup    string `json:"backup,omitempty"`
+	Size      uint64 `json:"size,omitempty"`
+	Removed   int    `json:"removed,omitempty"`
+	Reclaimed uint64 `json:"reclaimed,omitempty"`
+
+	// Number of events of the same kind not sent since
+	// the last notification, because of rate limiting
+	Suppressed int `json:"suppressed,omitempty"`
+}
+
+// Field is a detail of an event, such as the size of a backup
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Notifier sends notifications of events to somewhere people will see them
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+	String() string
+}
+
+// Recorder is implemented by notifiers that summarise events, such as in a
+// digest. Every event is recorded, regardless of the events notifications
+// are sent for and of rate limiting.
+type Recorder interface {
+	Record(event Event)
+}
+
+// Runner is implemented by notifiers that send notifications on their
+// own schedule, which they should do until the context is cancelled
+type Runner interface {
+	Run(ctx context.Context)
+}
+
+// Dispatcher sends events for a server to all of its notifiers, limiting
+// how often notifications are sent for each kind of event
+type Dispatcher struct {
+	notifiers []Notifier
+	recorders []Recorder
+	server    string
+	events    map[Kind]bool
+	rateLimit time.Duration
+
+	mu         sync.Mutex
+	lastSent   map[Kind]time.Time
+	suppressed map[Kind]int
+}
+
+// New creates a dispatcher with a notifier for each webhook in the options,
+// and for email if an SMTP server is set. Invalid notifiers are logged and
+// ignored, rather than stopping backups.
+func New(opts config.Options) *Dispatcher {
+	d := &Dispatcher{
+		server:     opts.ServerName,
+		events:     make(map[Kind]bool),
+		rateLimit:  opts.Notify.RateLimit,
+		lastSent:   make(map[Kind]time.Time),
+		suppressed: make(map[Kind]int),
+	}
+	if d.server == "" {
+		d.server = opts.ServerLabel()
+	}
+	for _, kind := range opts.Notify.Events {
+		d.events[Kind(kind)] = true
+	}
+
+	for _, url := range opts.Notify.Webhooks {
+		webhook, err := NewWebhook(url, opts.Notify.Retries)
+		if err != nil {
+			logrus.WithField("prefix", "notify").
+				WithError(err).
+				Error("ignoring invalid webhook")
+			continue
+		}
+		d.Add(webhook)
+	}
+
+	if opts.Notify.SMTPHost != "" {
+		email, err := NewEmail(opts.Notify)
+		if err != nil {
+			logrus.WithField("prefix", "notify").
+				WithError(err).
+				Error("not sending emails")
+		} else {
+			d.Add(email)
+		}
+	}
+	return d
+}
+
+// Add sends events to another notifier
+func (d *Dispatcher) Add(notifier Notifier) {
+	d.notifiers = append(d.notifiers, notifier)
+	if recorder, ok := notifier.(Recorder); ok {
+		d.recorders = append(d.recorders, recorder)
+	}
+}
+
+// Enabled reports whether notifications are sent or recorded for a kind of
+// event, so that events don't need to be gathered if they'll never be used
+func (d *Dispatcher) Enabled(kind Kind) bool {
+	if d == nil {
+		return false
+	}
+	return len(d.recorders) > 0 || len(d.notifiers) > 0 && d.events[kind]
+}
+
+// Run runs any notifiers that send notifications on their own
+// schedule, until the context is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, notifier := range d.notifiers {
+		if runner, ok := notifier.(Runner); ok {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				runner.Run(ctx)
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// Send notifies every notifier of an event, unless a notification for the
+// same kind of event was sent too recently. Failures are only logged.
+func (d *Dispatcher) Send(event Event) {
+	if !d.Enabled(event.Kind) {
+		return
+	}
+	log := logrus.WithField("prefix", "notify").
+		WithField("event", event.Kind)
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.Server = d.server
+
+	for _, recorder := range d.recorders {
+		recorder.Record(event)
+	}
+	if !d.events[event.Kind] {
+		return
+	}
+	if !d.allow(&event) {
+		log.Debug("not sending notification, rate limited")
+		return
+	}
+
+	for _, notifier := range d.notifiers {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err := notifier.Notify(ctx, event)
+		cancel()
+		if err != nil {
+			log.WithError(err).
+				Warnf("failed to send notification to %s", notifier)
+		}
+	}
+}
+
+// allow checks the rate limit for an event, counting suppressed
+// events so the next notification that is sent can include them
+func (d *Dispatcher) allow(event *Event) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	last, ok := d.lastSent[event.Kind]
+	if ok && d.rateLimit > 0 && event.Time.Sub(last) < d.rateLimit {
+		d.suppressed[event.Kind]++
+		return false
+	}
+
+	d.lastSent[event.Kind] = event.Time
+	event.Suppressed = d.suppressed[event.Kind]
+	delete(d.suppressed, event.Kind)
+	return true
+}
+
+// Summary describes the event in a sentence, including any
+// events that were suppressed by rate limiting
+func (event Event) Summary() string {
+	msg := event.Message
+	if event.Suppressed > 0 {
+		msg += fmt.Sprintf(" (%d similar events not sent)", event.Suppressed)
+	}
+	return msg
+}
@@ -0,0 +1,224 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Payload formats that webhooks can send
+const (
+	FormatJSON    = "json"
+	FormatDiscord = "discord"
+	FormatSlack   = "slack"
+)
+
+// Colours used for each kind of event in Discord and Slack messages
+var colours = map[Kind]int{
+	BackupSucceeded: 0x2ecc71,
+	BackupFailed:    0xe74c3c,
+	Pruned:          0x3498db,
+	DiskFull:        0xe67e22,
+}
+
+// Webhook posts events to a URL, in the format expected by the service
+type Webhook struct {
+	url     string
+	format  string
+	retries uint
+	client  *http.Client
+
+	// Time to wait before the first retry, doubled after each attempt
+	backoff time.Duration
+}
+
+// NewWebhook creates a webhook for a URL. Discord and Slack URLs are
+// detected, and the format can be chosen by prefixing the URL with the
+// format name and a plus, for example for a proxy to either service.
+func NewWebhook(rawurl string, retries uint) (*Webhook, error) {
+	wh := &Webhook{
+		retries: retries,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		backoff: time.Second,
+	}
+
+	if i := strings.Index(rawurl, "+"); i > 0 && !strings.Contains(rawurl[:i], "/") {
+		wh.format, rawurl = rawurl[:i], rawurl[i+1:]
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("webhook %s must be an http or https URL", u.Redacted())
+	}
+	wh.url = u.String()
+
+	switch wh.format {
+	case "":
+		wh.format = detectFormat(u)
+	case FormatJSON, FormatDiscord, FormatSlack:
+	default:
+		return nil, fmt.Errorf("unknown webhook format '%s'", wh.format)
+	}
+	return wh, nil
+}
+
+func detectFormat(u *url.URL) string {
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case (host == "discord.com" || host == "discordapp.com" ||
+		strings.HasSuffix(host, ".discord.com")) &&
+		strings.HasPrefix(u.Path, "/api/webhooks/"):
+		return FormatDiscord
+	case host == "hooks.slack.com":
+		return FormatSlack
+	default:
+		return FormatJSON
+	}
+}
+
+// String describes the webhook without the path, which usually holds a secret
+func (wh *Webhook) String() string {
+	u, err := url.Parse(wh.url)
+	if err != nil {
+		return wh.format + " webhook"
+	}
+	return fmt.Sprintf("%s webhook at %s", wh.format, u.Host)
+}
+
+// Notify posts the event to the webhook, retrying if the request fails
+// or the server responds with a rate limit or server error
+func (wh *Webhook) Notify(ctx context.Context, event Event) error {
+	body, err := wh.payload(event)
+	if err != nil {
+		return err
+	}
+
+	wait := wh.backoff
+	for attempt := uint(0); ; attempt++ {
+		retryAfter, err := wh.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if retryAfter < 0 || attempt >= wh.retries {
+			return err
+		}
+
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		wait *= 2
+	}
+}
+
+// post sends a single request. If it fails, a negative retryAfter
+// means the request shouldn't be retried, and otherwise it is how
+// long the server asked to wait before retrying, if it said.
+func (wh *Webhook) post(ctx context.Context, body []byte) (retryAfter time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.url, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		// Strip the URL from the error, as it may contain a secret
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return 0, nil
+	}
+
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		if secs, e := strconv.Atoi(resp.Header.Get("Retry-After")); e == nil {
+			retryAfter = time.Duration(secs) * time.Second
+		}
+		return retryAfter, err
+	}
+	return -1, err
+}
+
+func (wh *Webhook) payload(event Event) ([]byte, error) {
+	title := fmt.Sprintf("[%s] %s", event.Server, event.Kind.Title())
+
+	switch wh.format {
+	case FormatDiscord:
+		type field struct {
+			Name   string `json:"name"`
+			Value  string `json:"value"`
+			Inline bool   `json:"inline"`
+		}
+		var fields []field
+		for _, f := range event.allFields() {
+			fields = append(fields, field{f.Name, f.Value, true})
+		}
+		return json.Marshal(map[string]interface{}{
+			"username": "mcbackup",
+			"embeds": []map[string]interface{}{{
+				"title":       title,
+				"description": event.Summary(),
+				"color":       colours[event.Kind],
+				"timestamp":   event.Time.Format(time.RFC3339),
+				"fields":      fields,
+			}},
+		})
+
+	case FormatSlack:
+		type field struct {
+			Title string `json:"title"`
+			Value string `json:"value"`
+			Short bool   `json:"short"`
+		}
+		var fields []field
+		for _, f := range event.allFields() {
+			fields = append(fields, field{f.Name, f.Value, true})
+		}
+		return json.Marshal(map[string]interface{}{
+			"text": title,
+			"attachments": []map[string]interface{}{{
+				"color":  fmt.Sprintf("#%06x", colours[event.Kind]),
+				"text":   event.Summary(),
+				"fields": fields,
+				"ts":     event.Time.Unix(),
+			}},
+		})
+
+	default:
+		return json.Marshal(event)
+	}
+}
+
+// allFields lists the event fields along with the error, for chat messages
+func (event Event) allFields() []Field {
+	if event.Error == "" {
+		return event.Fields
+	}
+	return append([]Field{{Name: "Error", Value: event.Error}}, event.Fields...)
+}
+
+var _ Notifier = &Webhook{}
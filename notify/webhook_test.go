@@ -0,0 +1,130 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookFormat(t *testing.T) {
+	tests := map[string]string{
+		"https://discord.com/api/webhooks/123/abc":     FormatDiscord,
+		"https://hooks.slack.com/services/T0/B0/xyz":   FormatSlack,
+		"https://example.com/hook":                     FormatJSON,
+		"slack+https://proxy.example.com/hook":         FormatSlack,
+		"discord+http://localhost:8080/api/webhooks/1": FormatDiscord,
+	}
+	for url, format := range tests {
+		wh, err := NewWebhook(url, 0)
+		if err != nil {
+			t.Errorf("%s: %v", url, err)
+			continue
+		}
+		if wh.format != format {
+			t.Errorf("%s: expected format %s, got %s", url, format, wh.format)
+		}
+	}
+
+	for _, url := range []string{"teams+https://example.com", "ftp://example.com"} {
+		if _, err := NewWebhook(url, 0); err == nil {
+			t.Errorf("%s: expected an error", url)
+		}
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	var requests int
+	var payload map[string]interface{}
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if status == http.StatusNoContent && requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	wh, err := NewWebhook("discord+"+server.URL, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wh.backoff = time.Millisecond
+
+	err = wh.Notify(context.Background(), Event{
+		Kind:    BackupFailed,
+		Server:  "survival",
+		Time:    time.Now(),
+		Message: "Backup mcb-2021-01-01-00:00 failed",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Errorf("expected 3 requests, got %d", requests)
+	}
+
+	embeds, _ := payload["embeds"].([]interface{})
+	if len(embeds) != 1 {
+		t.Fatalf("expected a single embed, got %v", payload)
+	}
+	if title := embeds[0].(map[string]interface{})["title"]; title != "[survival] Backup failed" {
+		t.Errorf("unexpected embed title %v", title)
+	}
+
+	// Client errors aren't retried
+	requests = 0
+	status = http.StatusBadRequest
+	err = wh.Notify(context.Background(), Event{Kind: BackupFailed})
+	if err == nil || requests != 1 {
+		t.Errorf("expected a single failed request, got %d (%v)", requests, err)
+	}
+}
+
+type recorder struct {
+	events []Event
+}
+
+func (r *recorder) Notify(ctx context.Context, event Event) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *recorder) String() string {
+	return "recorder"
+}
+
+func TestDispatcherRateLimit(t *testing.T) {
+	rec := &recorder{}
+	d := &Dispatcher{
+		notifiers:  []Notifier{rec},
+		events:     map[Kind]bool{BackupFailed: true, DiskFull: true},
+		rateLimit:  time.Hour,
+		lastSent:   make(map[Kind]time.Time),
+		suppressed: make(map[Kind]int),
+	}
+
+	start := time.Now()
+	d.Send(Event{Kind: BackupFailed, Time: start})
+	d.Send(Event{Kind: BackupFailed, Time: start.Add(15 * time.Minute)})
+	d.Send(Event{Kind: BackupFailed, Time: start.Add(30 * time.Minute)})
+	d.Send(Event{Kind: DiskFull, Time: start.Add(30 * time.Minute)})
+	d.Send(Event{Kind: BackupSucceeded, Time: start.Add(30 * time.Minute)})
+	d.Send(Event{Kind: BackupFailed, Time: start.Add(time.Hour)})
+
+	var kinds []Kind
+	for _, event := range rec.events {
+		kinds = append(kinds, event.Kind)
+	}
+	if len(kinds) != 3 || kinds[0] != BackupFailed || kinds[1] != DiskFull || kinds[2] != BackupFailed {
+		t.Fatalf("unexpected events sent: %v", kinds)
+	}
+	if rec.events[2].Suppressed != 2 {
+		t.Errorf("expected 2 suppressed events, got %d", rec.events[2].Suppressed)
+	}
+}
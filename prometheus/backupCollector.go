@@ -1,12 +1,13 @@
 package prometheus
 
 import (
-	"fmt"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/gorhill/cronexpr"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spritsail/mcbackup/backup"
 	"github.com/spritsail/mcbackup/config"
 	"github.com/spritsail/mcbackup/provider"
 )
@@ -14,16 +15,31 @@ import (
 type BackupCollector struct {
 	Provider provider.Provider
 	Interval time.Duration
+	CacheTTL time.Duration
+	server   string
 
-	backupLatest   *prometheus.Desc
-	backupOldest   *prometheus.Desc
-	backupCount    *prometheus.Desc
-	backupInterval *prometheus.Desc
+	// Listing backups can be slow, so the last listing is reused
+	// for scrapes within the cache TTL
+	mu           sync.Mutex
+	cached       backup.Backups
+	cachedAt     time.Time
+	scrapeErrors uint64
+
+	backupLatest      *prometheus.Desc
+	backupOldest      *prometheus.Desc
+	backupCount       *prometheus.Desc
+	backupInterval    *prometheus.Desc
+	providerUp        *prometheus.Desc
+	scrapeErrorsTotal *prometheus.Desc
+
+	verifyTimestamp *prometheus.Desc
+	verifyChecked   *prometheus.Desc
+	verifyCorrupt   *prometheus.Desc
 }
 
-func newBackupCollector(prov provider.Provider, opts config.Options) BackupCollector {
+func newBackupCollector(prov provider.Provider, opts config.Options) *BackupCollector {
 	labels := prometheus.Labels{
-		"mcserver": fmt.Sprintf("%s:%d", opts.Host, opts.Port),
+		"mcserver": opts.ServerLabel(),
 		"provider": opts.Provider,
 	}
 
@@ -35,9 +51,11 @@ func newBackupCollector(prov provider.Provider, opts config.Options) BackupColle
 		interval = next[1].Sub(next[0])
 	}
 
-	return BackupCollector{
+	return &BackupCollector{
 		Provider: prov,
 		Interval: interval,
+		CacheTTL: opts.MetricsCacheTTL,
+		server:   opts.ServerLabel(),
 
 		backupLatest: prometheus.NewDesc("mcbackup_backup_latest",
 			"Unix timestamp of the last successful backup",
@@ -55,37 +73,109 @@ func newBackupCollector(prov provider.Provider, opts config.Options) BackupColle
 			"Interval between backups",
 			nil, labels,
 		),
+		providerUp: prometheus.NewDesc("mcbackup_provider_up",
+			"Whether backups could be listed from the provider",
+			nil, labels,
+		),
+		scrapeErrorsTotal: prometheus.NewDesc("mcbackup_scrape_errors_total",
+			"Number of times listing backups from the provider failed",
+			nil, labels,
+		),
+
+		verifyTimestamp: prometheus.NewDesc("mcbackup_verify_timestamp",
+			"Unix timestamp of the last backup verification",
+			nil, labels,
+		),
+		verifyChecked: prometheus.NewDesc("mcbackup_verify_checked",
+			"Number of backups checked by the last verification",
+			nil, labels,
+		),
+		verifyCorrupt: prometheus.NewDesc("mcbackup_verify_corrupt",
+			"Number of backups found to be corrupt by the last verification",
+			nil, labels,
+		),
 	}
 }
 
-func (b BackupCollector) Describe(ch chan<- *prometheus.Desc) {
+func (b *BackupCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- b.backupLatest
 	ch <- b.backupOldest
 	ch <- b.backupCount
 	if b.Interval != 0 {
 		ch <- b.backupInterval
 	}
+	ch <- b.providerUp
+	ch <- b.scrapeErrorsTotal
+	ch <- b.verifyTimestamp
+	ch <- b.verifyChecked
+	ch <- b.verifyCorrupt
 }
 
-func (b BackupCollector) Collect(ch chan<- prometheus.Metric) {
-	backups, err := b.Provider.List()
+func (b *BackupCollector) Collect(ch chan<- prometheus.Metric) {
+	b.collectVerify(ch)
+	if b.Interval != 0 {
+		ch <- prometheus.MustNewConstMetric(b.backupInterval, prometheus.GaugeValue, b.Interval.Seconds())
+	}
+
+	backups, errors, err := b.list()
+	ch <- prometheus.MustNewConstMetric(b.scrapeErrorsTotal, prometheus.CounterValue, float64(errors))
 	if err != nil {
 		log.WithError(err).Warn("error collecting latest metrics")
+		ch <- prometheus.MustNewConstMetric(b.providerUp, prometheus.GaugeValue, 0)
 		return
 	}
+	ch <- prometheus.MustNewConstMetric(b.providerUp, prometheus.GaugeValue, 1)
+	ch <- prometheus.MustNewConstMetric(b.backupCount, prometheus.GaugeValue, float64(len(backups)))
 
-	// Sort so we can find the newest and oldest latest
-	sort.Sort(backups)
-
+	// There is no latest or oldest until the first backup is taken
+	if len(backups) == 0 {
+		return
+	}
 	latest := backups[len(backups)-1]
 	oldest := backups[0]
+	ch <- prometheus.MustNewConstMetric(b.backupLatest, prometheus.GaugeValue, float64(latest.When().Unix()))
+	ch <- prometheus.MustNewConstMetric(b.backupOldest, prometheus.GaugeValue, float64(oldest.When().Unix()))
+}
 
-	ch <- prometheus.MustNewConstMetric(b.backupLatest, prometheus.CounterValue, float64(latest.When().Unix()))
-	ch <- prometheus.MustNewConstMetric(b.backupOldest, prometheus.CounterValue, float64(oldest.When().Unix()))
-	ch <- prometheus.MustNewConstMetric(b.backupCount, prometheus.GaugeValue, float64(len(backups)))
-	if b.Interval != 0 {
-		ch <- prometheus.MustNewConstMetric(b.backupInterval, prometheus.GaugeValue, b.Interval.Seconds())
+// list returns the sorted backups, listing them from the provider again only
+// once the cache has expired. The total number of failed listings is returned
+// with them.
+func (b *BackupCollector) list() (backup.Backups, uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.cached != nil && time.Since(b.cachedAt) < b.CacheTTL {
+		return b.cached, b.scrapeErrors, nil
+	}
+
+	backups, err := b.Provider.List()
+	if err != nil {
+		b.scrapeErrors++
+		return nil, b.scrapeErrors, err
+	}
+
+	// Sort so we can find the newest and oldest
+	sort.Sort(backups)
+	if backups == nil {
+		backups = backup.Backups{}
 	}
+	b.cached = backups
+	b.cachedAt = time.Now()
+	return backups, b.scrapeErrors, nil
+}
+
+// collectVerify exports the result of the last verify run, if there was one
+func (b *BackupCollector) collectVerify(ch chan<- prometheus.Metric) {
+	verifyResults.Lock()
+	result, ok := verifyResults.servers[b.server]
+	verifyResults.Unlock()
+	if !ok {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(b.verifyTimestamp, prometheus.GaugeValue, float64(result.when.Unix()))
+	ch <- prometheus.MustNewConstMetric(b.verifyChecked, prometheus.GaugeValue, float64(result.checked))
+	ch <- prometheus.MustNewConstMetric(b.verifyCorrupt, prometheus.GaugeValue, float64(result.corrupt))
 }
 
-var _ prometheus.Collector = BackupCollector{}
+var _ prometheus.Collector = &BackupCollector{}
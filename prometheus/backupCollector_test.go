@@ -0,0 +1,76 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spritsail/mcbackup/backup"
+	"github.com/spritsail/mcbackup/config"
+	"github.com/spritsail/mcbackup/provider"
+)
+
+// fakeProvider lists a fixed set of backups, or fails to
+type fakeProvider struct {
+	provider.Provider
+	backups backup.Backups
+	err     error
+	lists   int
+}
+
+func (fp *fakeProvider) List() (backup.Backups, error) {
+	fp.lists++
+	return fp.backups, fp.err
+}
+
+func (fp *fakeProvider) Create(context.Context, string, time.Time) (backup.Backup, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestCollectorEmptyProvider(t *testing.T) {
+	prov := &fakeProvider{}
+	collector := newBackupCollector(prov, config.Options{MetricsCacheTTL: time.Minute})
+
+	expected := `
+# HELP mcbackup_backup_count Number of backups stored in total
+# TYPE mcbackup_backup_count gauge
+mcbackup_backup_count{mcserver=":0",provider=""} 0
+# HELP mcbackup_provider_up Whether backups could be listed from the provider
+# TYPE mcbackup_provider_up gauge
+mcbackup_provider_up{mcserver=":0",provider=""} 1
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"mcbackup_backup_count", "mcbackup_provider_up")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// The listing should be cached for the next scrape
+	testutil.CollectAndCount(collector)
+	if prov.lists != 1 {
+		t.Errorf("provider listed %d times, should be cached after the first", prov.lists)
+	}
+}
+
+func TestCollectorFailingProvider(t *testing.T) {
+	prov := &fakeProvider{err: errors.New("dataset not found")}
+	collector := newBackupCollector(prov, config.Options{MetricsCacheTTL: time.Minute})
+	testutil.CollectAndCount(collector)
+
+	expected := `
+# HELP mcbackup_provider_up Whether backups could be listed from the provider
+# TYPE mcbackup_provider_up gauge
+mcbackup_provider_up{mcserver=":0",provider=""} 0
+# HELP mcbackup_scrape_errors_total Number of times listing backups from the provider failed
+# TYPE mcbackup_scrape_errors_total counter
+mcbackup_scrape_errors_total{mcserver=":0",provider=""} 2
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"mcbackup_provider_up", "mcbackup_scrape_errors_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -13,11 +13,12 @@ import (
 
 var log = logrus.WithField("prefix", "prometheus")
 
-func Serve(addr string, opts config.Options, prov provider.Provider) error {
-	// Collect metrics for the provided backup provider
-	collector := newBackupCollector(prov, opts)
-	prom.MustRegister(collector)
+// Register collects metrics for the backups of a server
+func Register(opts config.Options, prov provider.Provider) error {
+	return prom.Register(newBackupCollector(prov, opts))
+}
 
+func Serve(addr string) error {
 	log.Info("serving metrics at " + addr)
 
 	promHandler := promhttp.Handler()
@@ -28,6 +29,10 @@ func Serve(addr string, opts config.Options, prov provider.Provider) error {
 		promHandler.ServeHTTP(w, r)
 	}
 
-	http.Handle("/metrics", handler)
-	return http.ListenAndServe(addr, nil)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	// Probes are frequent, so aren't logged
+	mux.HandleFunc("/healthz", handleHealth)
+	mux.HandleFunc("/readyz", handleReady)
+	return http.ListenAndServe(addr, mux)
 }
@@ -0,0 +1,95 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// checkTimeout limits how long the readiness endpoint waits for checks
+const checkTimeout = 10 * time.Second
+
+// HealthCheck reports on one aspect of whether backups can be taken
+type HealthCheck struct {
+	Name  string
+	Check func() error
+}
+
+var readiness struct {
+	sync.Mutex
+	checks []HealthCheck
+}
+
+// AddReadiness adds checks that must pass for mcbackup to be ready
+func AddReadiness(checks ...HealthCheck) {
+	readiness.Lock()
+	defer readiness.Unlock()
+	readiness.checks = append(readiness.checks, checks...)
+}
+
+type healthStatus struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// handleHealth reports that mcbackup is running and able to serve requests
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, healthStatus{Status: "ok"})
+}
+
+// handleReady runs every readiness check, reporting unavailable if any fail
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	readiness.Lock()
+	checks := readiness.checks
+	readiness.Unlock()
+
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result, len(checks))
+	for _, check := range checks {
+		go func(check HealthCheck) {
+			results <- result{check.Name, check.Check()}
+		}(check)
+	}
+
+	status := healthStatus{
+		Status: "ok",
+		Checks: make(map[string]string, len(checks)),
+	}
+	for _, check := range checks {
+		status.Checks[check.Name] = "timed out"
+	}
+
+	timeout := time.After(checkTimeout)
+wait:
+	for range checks {
+		select {
+		case res := <-results:
+			if res.err != nil {
+				status.Checks[res.name] = res.err.Error()
+			} else {
+				status.Checks[res.name] = "ok"
+			}
+		case <-timeout:
+			break wait
+		}
+	}
+	for _, msg := range status.Checks {
+		if msg != "ok" {
+			status.Status = "unavailable"
+		}
+	}
+
+	writeHealth(w, status)
+}
+
+func writeHealth(w http.ResponseWriter, status healthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	if status.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
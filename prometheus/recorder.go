@@ -0,0 +1,138 @@
+package prometheus
+
+import (
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/spritsail/mcbackup/config"
+)
+
+// Stages of taking and pruning backups, for counting failures
+const (
+	StageRcon   = "rcon"
+	StageSave   = "save"
+	StageCreate = "create"
+	StagePrune  = "prune"
+)
+
+// The replica label names the replica that backups are copied to, so that
+// it doesn't clash with the main provider when both are the same type. It
+// is empty for the main provider.
+var serverLabels = []string{"mcserver", "provider", "replica"}
+
+var (
+	backupDuration = prom.NewHistogramVec(prom.HistogramOpts{
+		Name:    "mcbackup_backup_duration_seconds",
+		Help:    "Time taken to create each backup",
+		Buckets: prom.ExponentialBuckets(1, 2, 14),
+	}, serverLabels)
+	backupSize = prom.NewGaugeVec(prom.GaugeOpts{
+		Name: "mcbackup_backup_last_size_bytes",
+		Help: "Size of the contents of the last backup",
+	}, serverLabels)
+	backupSpaceUsed = prom.NewGaugeVec(prom.GaugeOpts{
+		Name: "mcbackup_backup_last_disk_bytes",
+		Help: "Space on disk used by the last backup",
+	}, serverLabels)
+	storedBytes = prom.NewGaugeVec(prom.GaugeOpts{
+		Name: "mcbackup_stored_bytes",
+		Help: "Space on disk used by all backups currently stored",
+	}, serverLabels)
+	stageResults = prom.NewCounterVec(prom.CounterOpts{
+		Name: "mcbackup_stage_total",
+		Help: "Number of times each stage of a backup or prune succeeded or failed",
+	}, append(serverLabels, "stage", "result"))
+	prunedBackups = prom.NewCounterVec(prom.CounterOpts{
+		Name: "mcbackup_pruned_total",
+		Help: "Number of backups removed by pruning",
+	}, serverLabels)
+	prunedBytes = prom.NewCounterVec(prom.CounterOpts{
+		Name: "mcbackup_pruned_bytes_total",
+		Help: "Space on disk reclaimed by pruning",
+	}, serverLabels)
+	replicationResults = prom.NewCounterVec(prom.CounterOpts{
+		Name: "mcbackup_replication_total",
+		Help: "Number of times copying a backup to each replica succeeded or failed",
+	}, append(serverLabels, "result"))
+	replicationLatest = prom.NewGaugeVec(prom.GaugeOpts{
+		Name: "mcbackup_replication_latest",
+		Help: "Unix timestamp of the latest backup copied to each replica",
+	}, serverLabels)
+	replicationLag = prom.NewGaugeVec(prom.GaugeOpts{
+		Name: "mcbackup_replication_lag_seconds",
+		Help: "Time between the latest backup and the latest backup copied to each replica",
+	}, serverLabels)
+)
+
+func init() {
+	prom.MustRegister(backupDuration, backupSize, backupSpaceUsed, storedBytes,
+		stageResults, prunedBackups, prunedBytes,
+		replicationResults, replicationLatest, replicationLag)
+}
+
+// Recorder records metrics as backups are taken and pruned for a server
+type Recorder struct {
+	labels prom.Labels
+}
+
+func NewRecorder(opts config.Options) *Recorder {
+	var replica string
+	if opts.IsReplica() {
+		replica = opts.Provider
+	}
+	return &Recorder{labels: prom.Labels{
+		"mcserver": opts.ServerLabel(),
+		"provider": opts.Provider,
+		"replica":  replica,
+	}}
+}
+
+// Backup records a newly created backup
+func (r *Recorder) Backup(elapsed time.Duration, size, used uint64) {
+	backupDuration.With(r.labels).Observe(elapsed.Seconds())
+	backupSize.With(r.labels).Set(float64(size))
+	backupSpaceUsed.With(r.labels).Set(float64(used))
+}
+
+// Stage counts whether a stage succeeded or failed
+func (r *Recorder) Stage(stage string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	stageResults.MustCurryWith(r.labels).
+		WithLabelValues(stage, result).Inc()
+}
+
+// Pruned records the backups removed by a prune
+func (r *Recorder) Pruned(count int, reclaimed uint64) {
+	prunedBackups.With(r.labels).Add(float64(count))
+	prunedBytes.With(r.labels).Add(float64(reclaimed))
+}
+
+// Stored records the space used by all backups currently stored
+func (r *Recorder) Stored(stored uint64) {
+	storedBytes.With(r.labels).Set(float64(stored))
+}
+
+// Replicated records an attempt to copy a backup to a replica, along with
+// the time of the latest backup the replica holds and how far behind it is
+func (r *Recorder) Replicated(replica string, err error, latest time.Time, lag time.Duration) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	labels := prom.Labels{"replica": replica}
+	for name, value := range r.labels {
+		if name != "replica" {
+			labels[name] = value
+		}
+	}
+	replicationResults.MustCurryWith(labels).
+		WithLabelValues(result).Inc()
+
+	if !latest.IsZero() {
+		replicationLatest.With(labels).Set(float64(latest.Unix()))
+		replicationLag.With(labels).Set(lag.Seconds())
+	}
+}
@@ -0,0 +1,29 @@
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spritsail/mcbackup/config"
+)
+
+func TestReplicaRecorder(t *testing.T) {
+	opts := config.Options{Provider: "tar"}
+	opts.ServerName = "replicated"
+	primary := NewRecorder(opts)
+	replica := &Recorder{labels: map[string]string{
+		"mcserver": opts.ServerLabel(),
+		"provider": "tar",
+		"replica":  "tar",
+	}}
+
+	// A replica of the same type as the main provider keeps its own series
+	primary.Stored(100)
+	replica.Stored(50)
+	if got := testutil.ToFloat64(storedBytes.With(primary.labels)); got != 100 {
+		t.Errorf("expected the main provider to store 100 bytes, got %v", got)
+	}
+	if got := testutil.ToFloat64(storedBytes.With(replica.labels)); got != 50 {
+		t.Errorf("expected the replica to store 50 bytes, got %v", got)
+	}
+}
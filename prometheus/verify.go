@@ -0,0 +1,30 @@
+package prometheus
+
+import (
+	"sync"
+	"time"
+)
+
+type verifyResult struct {
+	when    time.Time
+	checked int
+	corrupt int
+}
+
+// verifyResults holds the outcome of the most recent verify run for each
+// server, so that it can be exported by the collector
+var verifyResults = struct {
+	sync.Mutex
+	servers map[string]verifyResult
+}{servers: make(map[string]verifyResult)}
+
+// RecordVerify records the outcome of a verify run for the next scrape
+func RecordVerify(server string, when time.Time, checked, corrupt int) {
+	verifyResults.Lock()
+	defer verifyResults.Unlock()
+	verifyResults.servers[server] = verifyResult{
+		when:    when,
+		checked: checked,
+		corrupt: corrupt,
+	}
+}
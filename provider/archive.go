@@ -2,15 +2,38 @@ package provider
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/spritsail/mcbackup/backup"
+	"github.com/spritsail/mcbackup/config"
 	"golang.org/x/sys/unix"
 )
 
+// partialSuffix marks archives that are still being written,
+// or were left incomplete by a failed backup
+const partialSuffix = ".partial"
+
 type ArchiveProvider struct {
 	SourceDirectory string `short:"s" long:"source-dir" description:"Minecraft server directory to backup" env:"SOURCE_DIRECTORY" required:"true"`
 	BackupDirectory string `short:"b" long:"backup-dir" description:"Directory to save backup archives" env:"BACKUP_DIRECTORY" required:"true"`
+
+	Exclude []string `short:"x" long:"exclude" description:"gitignore-style pattern of files to exclude from backups, in addition to those listed in .mcbackupignore" env:"BACKUP_EXCLUDE" env-delim:","`
+	Include []string `short:"i" long:"include" description:"gitignore-style pattern of files to back up, even if they are otherwise excluded" env:"BACKUP_INCLUDE" env-delim:","`
+
+	CleanPartial time.Duration `long:"clean-partial" description:"remove incomplete backups that haven't been written to for this long, or 0 to leave them" env:"CLEAN_PARTIAL" default:"0"`
+
+	Encryption
+
+	// Name of the top-level directory in archives, when it differs from the
+	// source directory, such as when archiving a copy of another backup
+	root string
 }
 
 func (opts *ArchiveProvider) InitArchive() (err error) {
@@ -19,7 +42,37 @@ func (opts *ArchiveProvider) InitArchive() (err error) {
 		return
 	}
 
-	return checkDirectory(opts.SourceDirectory, "source")
+	err = checkDirectory(opts.SourceDirectory, "source")
+	if err != nil {
+		return
+	}
+
+	return opts.initEncryption(opts.BackupDirectory)
+}
+
+// Check ensures both directories are still accessible, without
+// attempting to create them as InitArchive does
+func (opts *ArchiveProvider) Check() error {
+	if err := unix.Access(opts.SourceDirectory, unix.R_OK|unix.X_OK); err != nil {
+		return fmt.Errorf("source directory %s: %v", opts.SourceDirectory, err)
+	}
+	if err := unix.Access(opts.BackupDirectory, unix.W_OK|unix.X_OK); err != nil {
+		return fmt.Errorf("backup directory %s: %v", opts.BackupDirectory, err)
+	}
+	return nil
+}
+
+// Capacity reports the space used on the filesystem holding the backup directory
+func (opts *ArchiveProvider) Capacity() (used uint64, total uint64, err error) {
+	var stat unix.Statfs_t
+	err = unix.Statfs(opts.BackupDirectory, &stat)
+	if err != nil {
+		return
+	}
+	// Space reserved for root isn't available for backups, the same as df
+	used = (stat.Blocks - stat.Bfree) * uint64(stat.Bsize)
+	total = used + stat.Bavail*uint64(stat.Bsize)
+	return
 }
 
 func checkDirectory(path string, typ string) (err error) {
@@ -58,3 +111,255 @@ func checkDirectory(path string, typ string) (err error) {
 
 	return
 }
+
+// createArchive writes a new archive with the given file name to the backup
+// directory. The archive is written under a partial name, then synced and
+// renamed into place once complete, so that an incomplete archive is never
+// mistaken for a complete backup.
+func (opts *ArchiveProvider) createArchive(filename string, write func(out io.Writer) error) (fpath string, err error) {
+	fpath = path.Join(opts.BackupDirectory, filename)
+	partial := fpath + partialSuffix
+
+	if _, err = os.Lstat(fpath); err == nil {
+		return "", fmt.Errorf("file already exists: %s", fpath)
+	}
+
+	out, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	err = write(out)
+	if err == nil {
+		err = out.Sync()
+	}
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(partial, fpath)
+	}
+	if err != nil {
+		// Don't leave a broken archive lying around
+		os.Remove(partial)
+		return "", err
+	}
+
+	// Ensure the rename itself is persisted
+	return fpath, syncDirectory(opts.BackupDirectory)
+}
+
+// storeArchive creates a new archive for a backup, saving the manifest
+// alongside it if there is one
+func (opts *ArchiveProvider) storeArchive(name string, when time.Time, ext string, write func(out io.Writer) error, m *backup.Manifest) (backup.Backup, error) {
+	fpath, err := opts.createArchive(name+"."+ext, write)
+	if err != nil {
+		return nil, err
+	}
+
+	bkup := &ArchiveBackup{
+		path:   fpath,
+		name:   name,
+		when:   when,
+		reason: backup.Unknown,
+	}
+	if m != nil {
+		opts.saveManifest(bkup, m)
+	}
+	return bkup, nil
+}
+
+func syncDirectory(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// cleanPartial removes an incomplete archive if it has been left for longer
+// than the configured time. Recently modified archives may still be in the
+// process of being written, so they are always left alone.
+func (opts *ArchiveProvider) cleanPartial(mcbOpts *config.Options, info os.FileInfo) {
+	log := logrus.WithField("prefix", "archive").
+		WithField("filename", info.Name())
+
+	age := time.Since(info.ModTime())
+	if opts.CleanPartial == 0 || age < opts.CleanPartial {
+		log.Debug("ignoring incomplete backup")
+		return
+	}
+
+	if mcbOpts.DryRun {
+		log.Infof("would remove incomplete backup, last written %s ago", age.Round(time.Second))
+		return
+	}
+
+	log.Infof("removing incomplete backup, last written %s ago", age.Round(time.Second))
+	err := os.Remove(path.Join(opts.BackupDirectory, info.Name()))
+	if err != nil {
+		log.WithError(err).Warn("failed to remove incomplete backup")
+	}
+}
+
+// saveManifest writes the manifest for a newly created archive alongside it.
+// The archive itself is still usable without it, so failures are only logged.
+func (opts *ArchiveProvider) saveManifest(ab *ArchiveBackup, m *backup.Manifest) {
+	m.Finish()
+	err := writeManifest(ab.manifestPath(), func(w io.Writer) error {
+		return opts.encryptManifest(w, m)
+	})
+	if err != nil {
+		logrus.WithField("prefix", "archive").
+			WithField("backup", ab.name).
+			WithError(err).
+			Warn("failed to write backup manifest")
+	}
+}
+
+// Manifest loads the manifest written alongside an archive
+func (opts *ArchiveProvider) Manifest(bkup backup.Backup) (*backup.Manifest, error) {
+	ab, ok := bkup.(*ArchiveBackup)
+	if !ok {
+		return nil, fmt.Errorf("backup %s is not an archive", bkup.Name())
+	}
+	return opts.readManifest(ab)
+}
+
+// readManifest reads the manifest of an archive, decrypting it if necessary
+func (opts *ArchiveProvider) readManifest(ab *ArchiveBackup) (*backup.Manifest, error) {
+	return readManifest(ab.manifestPath(), func(r io.Reader) (*backup.Manifest, error) {
+		return opts.decryptManifest(ab.manifestPath(), r)
+	})
+}
+
+func (opts *ArchiveProvider) Open(bkup backup.Backup) (io.ReadCloser, string, error) {
+	ab, ok := bkup.(*ArchiveBackup)
+	if !ok {
+		return nil, "", fmt.Errorf("backup %s is not an archive", bkup.Name())
+	}
+	file, err := os.Open(ab.path)
+	if err != nil {
+		return nil, "", err
+	}
+	return file, filepath.Base(ab.path), nil
+}
+
+// verifyArchive checks the contents of an archive against its manifest. Archives
+// without a manifest are still read in full to check they aren't truncated.
+func (opts *ArchiveProvider) verifyArchive(ab *ArchiveBackup, read func(entryFunc) error) error {
+	m, err := opts.readManifest(ab)
+	if os.IsNotExist(err) {
+		logrus.WithField("prefix", "archive").
+			WithField("backup", ab.name).
+			Warn("backup has no manifest, only checking that it can be read")
+		return drainContents(read)
+	} else if err != nil {
+		return fmt.Errorf("reading manifest: %v", err)
+	}
+
+	return verifyContents(m, read)
+}
+
+// walkSource walks the source directory, calling fn for every file with the
+// slash-separated name it should be given inside an archive. Names are rooted
+// at the base name of the source directory, in the same way as tar(1) would.
+func (opts *ArchiveProvider) walkSource(fn func(fpath, name string, info os.FileInfo) error) error {
+	root := filepath.Clean(opts.SourceDirectory)
+	base := filepath.Base(root)
+	if opts.root != "" {
+		base = opts.root
+	}
+
+	// Never archive the backups into themselves, if they're stored locally
+	var backupDir string
+	if opts.BackupDirectory != "" {
+		var err error
+		backupDir, err = filepath.Abs(opts.BackupDirectory)
+		if err != nil {
+			return err
+		}
+	}
+
+	filter, err := opts.loadFilter()
+	if err != nil {
+		return fmt.Errorf("loading exclude patterns: %v", err)
+	}
+
+	return filepath.Walk(root, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("traversing %s: %v", fpath, err)
+		}
+
+		if info.IsDir() {
+			abs, err := filepath.Abs(fpath)
+			if err != nil {
+				return err
+			}
+			// Files moved aside by an in-place restore are already backed up
+			if abs == backupDir || strings.HasPrefix(info.Name(), asidePrefix) {
+				return filepath.SkipDir
+			}
+		}
+
+		rel, err := filepath.Rel(root, fpath)
+		if err != nil {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+		if rel != "." && filter.Excluded(rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		return fn(fpath, path.Join(base, rel), info)
+	})
+}
+
+// listArchives finds all mcbackup-managed archives in the backup directory
+// that have the given file extension, whether they are encrypted or not
+func (opts *ArchiveProvider) listArchives(mcbOpts *config.Options, ext string) (backup.Backups, error) {
+	infos, err := ioutil.ReadDir(opts.BackupDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	var bkups backup.Backups
+	for _, info := range infos {
+		if !mcbOpts.IsMcbackup(info.Name()) {
+			continue
+		}
+		if strings.HasSuffix(info.Name(), partialSuffix) &&
+			hasArchiveExt(strings.TrimSuffix(info.Name(), partialSuffix), ext) {
+			opts.cleanPartial(mcbOpts, info)
+			continue
+		}
+		if !hasArchiveExt(info.Name(), ext) {
+			continue
+		}
+
+		when, err := mcbOpts.ParseBackupName(info.Name())
+		if err != nil {
+			return nil, err
+		}
+		// Recreate the backup name from the parsed value
+		// This gets around the issue of trying to remove the file extension
+		backupName, err := mcbOpts.GenBackupName(when)
+		if err != nil {
+			return nil, err
+		}
+		archiveBackup := &ArchiveBackup{
+			path:   path.Join(opts.BackupDirectory, info.Name()),
+			name:   backupName,
+			when:   when,
+			reason: backup.Unknown,
+		}
+		bkups = append(bkups, archiveBackup)
+	}
+
+	return bkups, nil
+}
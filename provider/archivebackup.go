@@ -22,8 +22,26 @@ func (ab *ArchiveBackup) When() time.Time {
 	return ab.when
 }
 
+func (ab *ArchiveBackup) Path() string {
+	return ab.path
+}
+
 func (ab *ArchiveBackup) Delete() error {
-	return os.Remove(ab.path)
+	err := os.Remove(ab.path)
+	if err != nil {
+		return err
+	}
+
+	// Older backups may not have a manifest
+	err = os.Remove(ab.manifestPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (ab *ArchiveBackup) manifestPath() string {
+	return manifestName(ab.path)
 }
 
 func (ab *ArchiveBackup) Size() (uint64, error) {
@@ -52,3 +70,4 @@ func (ab *ArchiveBackup) SetReason(r backup.Reason) {
 }
 
 var _ backup.Backup = &ArchiveBackup{}
+var _ backup.Pather = &ArchiveBackup{}
@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"strings"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/ulikunitz/xz"
+)
+
+// compressor wraps tar streams in a compression algorithm
+type compressor struct {
+	// Extensions accepted for archives using this algorithm.
+	// The first is used as the default.
+	extensions []string
+
+	// Range of compression levels accepted, besides 0 for the default.
+	// Algorithms without a configurable level have a maximum of 0.
+	minLevel, maxLevel int
+
+	// Wraps a writer to compress everything written to it. A level of 0
+	// uses the default level, and threads of 0 uses all available CPUs
+	// where the algorithm supports it.
+	writer func(w io.Writer, level int, threads int) (io.WriteCloser, error)
+
+	// Wraps a reader to decompress everything read from it
+	reader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var compressors = map[string]*compressor{
+	"gzip": {
+		extensions: []string{"tar.gz", "tgz"},
+		minLevel:   gzip.BestSpeed,
+		maxLevel:   gzip.BestCompression,
+		writer: func(w io.Writer, level int, _ int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = gzip.DefaultCompression
+			}
+			return gzip.NewWriterLevel(w, level)
+		},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	"bzip2": {
+		extensions: []string{"tar.bz2", "tbz2"},
+		minLevel:   bzip2.BestSpeed,
+		maxLevel:   bzip2.BestCompression,
+		writer: func(w io.Writer, level int, _ int) (io.WriteCloser, error) {
+			return bzip2.NewWriter(w, &bzip2.WriterConfig{Level: level})
+		},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			return bzip2.NewReader(r, nil)
+		},
+	},
+	"lz4": {
+		extensions: []string{"tar.lz4", "tlz4"},
+		// The level is the search depth of high compression mode, which
+		// makes no difference beyond the size of the window
+		minLevel: 1,
+		maxLevel: 1 << 16,
+		writer: func(w io.Writer, level int, _ int) (io.WriteCloser, error) {
+			lz4w := lz4.NewWriter(w)
+			lz4w.Header.CompressionLevel = level
+			return lz4w, nil
+		},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(lz4.NewReader(r)), nil
+		},
+	},
+	"xz": {
+		extensions: []string{"tar.xz", "txz"},
+		writer: func(w io.Writer, _ int, _ int) (io.WriteCloser, error) {
+			return xz.NewWriter(w)
+		},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			xzr, err := xz.NewReader(r)
+			return ioutil.NopCloser(xzr), err
+		},
+	},
+	"zstd": {
+		extensions: []string{"tar.zst", "tzst"},
+		minLevel:   1,
+		maxLevel:   22,
+		writer: func(w io.Writer, level int, threads int) (io.WriteCloser, error) {
+			zlevel := zstd.SpeedDefault
+			if level != 0 {
+				zlevel = zstd.EncoderLevelFromZstd(level)
+			}
+			if threads < 1 {
+				threads = runtime.GOMAXPROCS(0)
+			}
+			return zstd.NewWriter(w,
+				zstd.WithEncoderLevel(zlevel),
+				zstd.WithEncoderConcurrency(threads))
+		},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			dec, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return dec.IOReadCloser(), nil
+		},
+	},
+}
+
+// Short aliases for the compression algorithms
+var compressorAliases = map[string]string{
+	"gz":  "gzip",
+	"bz2": "bzip2",
+	"zst": "zstd",
+}
+
+// findCompressor looks up a compression algorithm by name
+func findCompressor(algo string) (*compressor, error) {
+	algo = strings.ToLower(algo)
+	if alias, ok := compressorAliases[algo]; ok {
+		algo = alias
+	}
+	comp, ok := compressors[algo]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression algorithm '%s'", algo)
+	}
+	return comp, nil
+}
+
+// checkLevel ensures the compression level is supported by the algorithm
+func (c *compressor) checkLevel(level int) error {
+	if level == 0 || (level >= c.minLevel && level <= c.maxLevel) {
+		return nil
+	}
+	return fmt.Errorf("must be between %d and %d, or 0 for the default", c.minLevel, c.maxLevel)
+}
+
+// checkExt ensures the file extension matches the compression algorithm
+func (c *compressor) checkExt(ext string) error {
+	for _, valid := range c.extensions {
+		if ext == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("extension must be one of .%s", strings.Join(c.extensions, " or ."))
+}
@@ -0,0 +1,314 @@
+package provider
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/spritsail/mcbackup/backup"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+
+	// OpenPGP keys without hash preferences fall back to RIPEMD-160, which
+	// must be available to encrypt to them, even though nothing is signed
+	_ "golang.org/x/crypto/ripemd160"
+)
+
+// Extensions added after that of the archive to mark encrypted backups
+const (
+	ageExtension = "age"
+	pgpExtension = "gpg"
+)
+
+// Encryption configures encrypting archives to public keys as they are
+// written, so that backups can be shipped somewhere untrusted. Only the
+// holders of the matching private keys can restore or verify them.
+type Encryption struct {
+	Recipients []string `long:"encrypt-to" description:"age public key (age1...), or file of age or OpenPGP public keys, to encrypt backups to. Can be given multiple times" env:"ENCRYPT_TO" env-delim:","`
+	Identities []string `long:"decrypt-identity" description:"age identity file or OpenPGP private key file, used to decrypt backups to restore or verify them. Can be given multiple times" env:"DECRYPT_IDENTITY" env-delim:","`
+	Passphrase string   `long:"decrypt-passphrase" description:"Passphrase protecting the OpenPGP private keys" env:"DECRYPT_PASSPHRASE" default-mask:"-"`
+	DecryptDir string   `long:"decrypt-dir" description:"Directory that OpenPGP tar archives and encrypted zip archives are decrypted into whilst they are restored or verified, which needs space for the whole archive. Defaults to the backup directory, or the system temporary directory for remote providers" env:"DECRYPT_DIRECTORY"`
+
+	ageRecipients []age.Recipient
+	pgpRecipients openpgp.EntityList
+	ageIdentities []age.Identity
+	pgpKeyring    openpgp.EntityList
+
+	// Where archives are decrypted to, as plaintext
+	decryptDir string
+}
+
+// initEncryption loads the keys, once the options are parsed. Archives are
+// decrypted into defaultDir unless another directory is configured.
+func (enc *Encryption) initEncryption(defaultDir string) error {
+	enc.decryptDir = defaultDir
+	if enc.DecryptDir != "" {
+		err := checkDirectory(enc.DecryptDir, "decrypt")
+		if err != nil {
+			return err
+		}
+		enc.decryptDir = enc.DecryptDir
+	}
+
+	for _, recipient := range enc.Recipients {
+		if strings.HasPrefix(recipient, "age1") {
+			r, err := age.ParseX25519Recipient(recipient)
+			if err != nil {
+				return fmt.Errorf("encryption recipient: %v", err)
+			}
+			enc.ageRecipients = append(enc.ageRecipients, r)
+			continue
+		}
+
+		data, err := ioutil.ReadFile(expandHome(recipient))
+		if err != nil {
+			return fmt.Errorf("encryption recipient: %v", err)
+		}
+		if pgp, err := readKeyRing(data); err == nil {
+			enc.pgpRecipients = append(enc.pgpRecipients, pgp...)
+		} else if rs, err := age.ParseRecipients(bytes.NewReader(data)); err == nil {
+			enc.ageRecipients = append(enc.ageRecipients, rs...)
+		} else {
+			return fmt.Errorf("encryption recipient %s is not an age or OpenPGP public key file", recipient)
+		}
+	}
+
+	if len(enc.ageRecipients) > 0 && len(enc.pgpRecipients) > 0 {
+		return errors.New("backups can't be encrypted to both age and OpenPGP recipients")
+	}
+	if len(enc.pgpRecipients) > 0 {
+		// Fail now rather than at the first backup if a key can't encrypt
+		w, err := enc.encrypt(ioutil.Discard)
+		if err != nil {
+			return fmt.Errorf("encryption recipient: %v", err)
+		}
+		w.Close()
+	}
+
+	for _, identity := range enc.Identities {
+		data, err := ioutil.ReadFile(expandHome(identity))
+		if err != nil {
+			return fmt.Errorf("decryption identity: %v", err)
+		}
+		if pgp, err := readKeyRing(data); err == nil {
+			err = decryptKeys(pgp, enc.Passphrase)
+			if err != nil {
+				return fmt.Errorf("decryption identity %s: %v", identity, err)
+			}
+			enc.pgpKeyring = append(enc.pgpKeyring, pgp...)
+		} else if ids, err := age.ParseIdentities(bytes.NewReader(data)); err == nil {
+			enc.ageIdentities = append(enc.ageIdentities, ids...)
+		} else {
+			return fmt.Errorf("decryption identity %s is not an age identity or OpenPGP private key file", identity)
+		}
+	}
+	return nil
+}
+
+// readKeyRing reads OpenPGP keys, either armored or binary
+func readKeyRing(data []byte) (keys openpgp.EntityList, err error) {
+	if bytes.Contains(data, []byte("-----BEGIN PGP")) {
+		keys, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keys, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err == nil && len(keys) == 0 {
+		err = errors.New("no OpenPGP keys found")
+	}
+	return
+}
+
+// decryptKeys unlocks any OpenPGP private keys protected by a passphrase
+func decryptKeys(keys openpgp.EntityList, passphrase string) error {
+	for _, entity := range keys {
+		if entity.PrivateKey == nil {
+			return fmt.Errorf("key %s has no private key", entity.PrimaryKey.KeyIdShortString())
+		}
+
+		privs := []*packet.PrivateKey{entity.PrivateKey}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil {
+				privs = append(privs, subkey.PrivateKey)
+			}
+		}
+		for _, priv := range privs {
+			if !priv.Encrypted {
+				continue
+			}
+			if passphrase == "" {
+				return fmt.Errorf("key %s is protected by a passphrase", entity.PrimaryKey.KeyIdShortString())
+			}
+			err := priv.Decrypt([]byte(passphrase))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// archiveExt is the extension of new archives, marked as encrypted if they are
+func (enc *Encryption) archiveExt(ext string) string {
+	switch {
+	case len(enc.ageRecipients) > 0:
+		return ext + "." + ageExtension
+	case len(enc.pgpRecipients) > 0:
+		return ext + "." + pgpExtension
+	default:
+		return ext
+	}
+}
+
+// encrypt wraps a writer to encrypt everything written to it, if archives
+// are encrypted. Closing it finishes encrypting, without closing w.
+func (enc *Encryption) encrypt(w io.Writer) (io.WriteCloser, error) {
+	switch {
+	case len(enc.ageRecipients) > 0:
+		return age.Encrypt(w, enc.ageRecipients...)
+	case len(enc.pgpRecipients) > 0:
+		return openpgp.Encrypt(w, enc.pgpRecipients, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+// decrypt wraps a reader of the named archive to decrypt it, if its
+// extension marks it as encrypted. Encrypted archives are only
+// authenticated once they have been read to the end.
+func (enc *Encryption) decrypt(name string, r io.Reader) (io.Reader, error) {
+	switch {
+	case strings.HasSuffix(name, "."+ageExtension):
+		if len(enc.ageIdentities) == 0 {
+			return nil, fmt.Errorf("%s is encrypted with age, but no identity is configured to decrypt it", path.Base(name))
+		}
+		return age.Decrypt(r, enc.ageIdentities...)
+	case strings.HasSuffix(name, "."+pgpExtension):
+		if len(enc.pgpKeyring) == 0 {
+			return nil, fmt.Errorf("%s is encrypted with OpenPGP, but no private key is configured to decrypt it", path.Base(name))
+		}
+		md, err := openpgp.ReadMessage(r, enc.pgpKeyring, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &stickyReader{r: md.UnverifiedBody}, nil
+	default:
+		return r, nil
+	}
+}
+
+// encryptManifest writes a manifest encrypted in the same way as archives,
+// so that it doesn't give away what an encrypted archive holds
+func (enc *Encryption) encryptManifest(w io.Writer, m *backup.Manifest) error {
+	ew, err := enc.encrypt(w)
+	if err != nil {
+		return err
+	}
+	err = m.Write(ew)
+	if e := ew.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// decryptManifest reads the named manifest, decrypting it if it is
+// encrypted. It is read to the end to authenticate it before it is parsed.
+func (enc *Encryption) decryptManifest(name string, r io.Reader) (*backup.Manifest, error) {
+	dr, err := enc.decrypt(name, r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(dr)
+	if err != nil {
+		return nil, err
+	}
+	return backup.ReadManifest(bytes.NewReader(data))
+}
+
+// decryptArchive decrypts an encrypted archive to a temporary file in the
+// decrypt directory, which is only returned once the whole archive is
+// authenticated. The caller must remove the file.
+func (enc *Encryption) decryptArchive(fpath string) (string, error) {
+	in, err := os.Open(fpath)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	return enc.decryptToFile(fpath, in)
+}
+
+// decryptToFile decrypts the named archive read from r to a temporary file,
+// in the same way as decryptArchive
+func (enc *Encryption) decryptToFile(name string, r io.Reader) (string, error) {
+	r, err := enc.decrypt(name, r)
+	if err != nil {
+		return "", err
+	}
+
+	tmp, err := ioutil.TempFile(enc.decryptDir, ".mcbackup-decrypt-")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(tmp, r)
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("decrypting %s: %v", path.Base(name), err)
+	}
+	return tmp.Name(), nil
+}
+
+// isEncrypted reports whether the named archive is encrypted
+func isEncrypted(name string) bool {
+	return strings.HasSuffix(name, "."+ageExtension) || strings.HasSuffix(name, "."+pgpExtension)
+}
+
+// manifestName is the name of the manifest stored alongside an archive,
+// marked as encrypted in the same way as the archive
+func manifestName(archive string) string {
+	name := archive + backup.ManifestExtension
+	if isEncrypted(archive) {
+		name += archive[strings.LastIndex(archive, "."):]
+	}
+	return name
+}
+
+// hasArchiveExt reports whether a file name has the extension of an
+// archive, whether it is encrypted or not
+func hasArchiveExt(name, ext string) bool {
+	if isEncrypted(name) {
+		name = name[:strings.LastIndex(name, ".")]
+	}
+	return strings.HasSuffix(name, "."+ext)
+}
+
+// stickyReader returns the same error for every read once it has reached the
+// end. OpenPGP messages are checked at each end they reach, and checking the
+// same message twice fails.
+type stickyReader struct {
+	r   io.Reader
+	err error
+}
+
+func (sr *stickyReader) Read(p []byte) (n int, err error) {
+	if sr.err != nil {
+		return 0, sr.err
+	}
+	n, sr.err = sr.r.Read(p)
+	return n, sr.err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,151 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/spritsail/mcbackup/config"
+	"golang.org/x/crypto/openpgp"
+)
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	dir, src, opts := newTestSource(t, map[string]string{"ops.json": "secret ops"})
+
+	// An age identity, and an OpenPGP key pair
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ageIdentity := filepath.Join(dir, "age.key")
+	err = ioutil.WriteFile(ageIdentity, []byte(identity.String()+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entity, err := openpgp.NewEntity("mcbackup", "", "mcbackup@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var public, private bytes.Buffer
+	err = entity.SerializePrivate(&private, nil)
+	if err == nil {
+		err = entity.Serialize(&public)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	pgpPublic := filepath.Join(dir, "pgp.pub")
+	pgpPrivate := filepath.Join(dir, "pgp.key")
+	ioutil.WriteFile(pgpPublic, public.Bytes(), 0644)
+	ioutil.WriteFile(pgpPrivate, private.Bytes(), 0600)
+
+	for _, test := range []struct {
+		name      string
+		init      func([]string, *config.Options) (Provider, []string, error)
+		recipient string
+		identity  string
+		ext       string
+	}{
+		{"tar-age", NewTar, identity.Recipient().String(), ageIdentity, ".tar.gz.age"},
+		{"tar-pgp", NewTar, pgpPublic, pgpPrivate, ".tar.gz.gpg"},
+		{"zip-age", NewZip, identity.Recipient().String(), ageIdentity, ".zip.age"},
+		{"zip-pgp", NewZip, pgpPublic, pgpPrivate, ".zip.gpg"},
+	} {
+		backupDir := filepath.Join(dir, test.name)
+		args := []string{"-s", src, "-b", backupDir, "--encrypt-to", test.recipient}
+		prov, _, err := test.init(append(args, "--decrypt-identity", test.identity), opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		bkup, err := prov.Create(context.Background(), "mcb-2021-01-01-00:00", time.Now())
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		path := bkup.(*ArchiveBackup).path
+		if !strings.HasSuffix(path, test.ext) {
+			t.Errorf("%s: encrypted archive is named %s", test.name, filepath.Base(path))
+		}
+		data, _ := ioutil.ReadFile(path)
+		if bytes.Contains(data, []byte("ops.json")) {
+			t.Errorf("%s: archive isn't encrypted", test.name)
+		}
+
+		// The manifest lists every file, so it is encrypted too
+		data, err = ioutil.ReadFile(path + ".manifest.json" + filepath.Ext(path))
+		if err != nil || bytes.Contains(data, []byte("ops.json")) {
+			t.Errorf("%s: manifest isn't encrypted: %v", test.name, err)
+		}
+		m, err := prov.Manifest(bkup)
+		if err != nil || m.Name != bkup.Name() {
+			t.Errorf("%s: failed to read the manifest: %v", test.name, err)
+		}
+
+		bkups, err := prov.List()
+		if err != nil || len(bkups) != 1 || bkups[0].Name() != bkup.Name() {
+			t.Errorf("%s: List() -> %v, %v, should be [%s]", test.name, bkups, err, bkup.Name())
+		}
+
+		err = prov.Verify(bkup)
+		if err != nil {
+			t.Errorf("%s: verify failed: %v", test.name, err)
+		}
+
+		dest := filepath.Join(dir, "restore-"+test.name)
+		err = prov.Restore(bkup, dest)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		restored, err := ioutil.ReadFile(filepath.Join(dest, "ops.json"))
+		if err != nil || string(restored) != "secret ops" {
+			t.Errorf("%s: restored file doesn't match the original: %q %v", test.name, restored, err)
+		}
+		// Archives decrypted whilst restoring them are cleaned up afterwards
+		leftover, _ := filepath.Glob(filepath.Join(backupDir, ".mcbackup-decrypt-*"))
+		if len(leftover) > 0 {
+			t.Errorf("%s: decrypted archive left behind: %v", test.name, leftover)
+		}
+
+		// Without the private key, the backup is still listed but can't be read
+		prov, _, err = test.init(args, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		bkups, err = prov.List()
+		if err != nil || len(bkups) != 1 {
+			t.Fatalf("%s: List() -> %v, %v", test.name, bkups, err)
+		}
+		err = prov.Restore(bkups[0], filepath.Join(dir, "nokey-"+test.name))
+		if err == nil {
+			t.Errorf("%s: restored without a private key", test.name)
+		}
+
+		// Nothing is restored from a tampered archive, even when it is only
+		// found to be tampered with at the end
+		data, _ = ioutil.ReadFile(path)
+		data[len(data)-5] ^= 0xff
+		err = ioutil.WriteFile(path, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prov, _, err = test.init(append(args, "--decrypt-identity", test.identity), opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		dest = filepath.Join(dir, "tampered-"+test.name)
+		err = prov.Restore(bkup, dest)
+		if err == nil {
+			t.Errorf("%s: restored a tampered archive", test.name)
+		}
+		if _, err := os.Stat(filepath.Join(dest, "ops.json")); err == nil {
+			t.Errorf("%s: files were restored from a tampered archive", test.name)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package provider
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFile is an optional file in the source directory listing
+// gitignore-style patterns of files to leave out of backups
+const ignoreFile = ".mcbackupignore"
+
+// filterPattern is a single compiled gitignore-style pattern
+type filterPattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Filter decides which files within the source directory are backed up,
+// following the same rules as a .gitignore file: the last matching pattern
+// wins, patterns prefixed with ! re-include files, and a trailing slash
+// only matches directories. Files within an excluded directory can't be
+// re-included as the directory is never walked.
+type Filter struct {
+	patterns []filterPattern
+}
+
+// Add appends a single gitignore-style pattern to the filter
+func (f *Filter) Add(pattern string) error {
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return nil
+	}
+
+	var fp filterPattern
+	if strings.HasPrefix(pattern, "!") {
+		fp.negate = true
+		pattern = pattern[1:]
+	}
+	// Allow escaping of a literal leading ! or #
+	if strings.HasPrefix(pattern, "\\!") || strings.HasPrefix(pattern, "\\#") {
+		pattern = pattern[1:]
+	}
+
+	if strings.HasSuffix(pattern, "/") {
+		fp.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+
+	// Patterns containing a slash are relative to the root,
+	// otherwise they match a file name at any depth
+	prefix := "^(?:.*/)?"
+	if strings.Contains(pattern, "/") {
+		prefix = "^"
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+
+	re, err := regexp.Compile(prefix + globToRegexp(pattern) + "$")
+	if err != nil {
+		return err
+	}
+	fp.re = re
+	f.patterns = append(f.patterns, fp)
+	return nil
+}
+
+// AddFile reads patterns from a file, one per line
+func (f *Filter) AddFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if err = f.Add(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Excluded reports whether a file should be left out of a backup.
+// rel is the slash-separated path relative to the source directory.
+func (f *Filter) Excluded(rel string, isDir bool) bool {
+	if f == nil {
+		return false
+	}
+
+	excluded := false
+	for _, fp := range f.patterns {
+		if fp.dirOnly && !isDir {
+			continue
+		}
+		if fp.re.MatchString(rel) {
+			excluded = !fp.negate
+		}
+	}
+	return excluded
+}
+
+// globToRegexp translates a gitignore glob into a regular expression
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			// Zero or more leading directories
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(regexp.QuoteMeta(glob[i:]))
+				return sb.String()
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, "/", "") + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+// addPatterns adds exclude patterns, followed by include
+// patterns which take precedence over them
+func (f *Filter) addPatterns(excludes []string, includes []string) error {
+	for _, pattern := range excludes {
+		if err := f.Add(pattern); err != nil {
+			return err
+		}
+	}
+	for _, pattern := range includes {
+		if err := f.Add("!" + strings.TrimPrefix(pattern, "!")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadFilter builds the filter from the ignore file in the source
+// directory if there is one, followed by the configured patterns
+func (opts *ArchiveProvider) loadFilter() (*Filter, error) {
+	filter := new(Filter)
+
+	err := filter.AddFile(filepath.Join(opts.SourceDirectory, ignoreFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	err = filter.addPatterns(opts.Exclude, opts.Include)
+	if err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
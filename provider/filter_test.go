@@ -0,0 +1,47 @@
+package provider
+
+import "testing"
+
+func TestFilterExcluded(t *testing.T) {
+	filter := new(Filter)
+	err := filter.addPatterns([]string{
+		"# comment",
+		"logs/",
+		"*.jar",
+		"/cache",
+		"plugins/dynmap/web/tiles/**",
+		"**/session.lock",
+	}, []string{
+		"minecraft_server.jar",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		rel      string
+		isDir    bool
+		excluded bool
+	}{
+		{"logs", true, true},
+		{"logs", false, false},
+		{"world/logs", true, true},
+		{"paper.jar", false, true},
+		{"plugins/worldedit.jar", false, true},
+		{"minecraft_server.jar", false, false},
+		{"cache", true, true},
+		{"world/cache", true, false},
+		{"plugins/dynmap/web/tiles/world/0_0.png", false, true},
+		{"plugins/dynmap/configuration.txt", false, false},
+		{"session.lock", false, true},
+		{"world/session.lock", false, true},
+		{"world/level.dat", false, false},
+	}
+	for _, test := range tests {
+		out := filter.Excluded(test.rel, test.isDir)
+		if out != test.excluded {
+			t.Errorf("Excluded(\"%s\", %t) -> %t, should be %t",
+				test.rel, test.isDir, out, test.excluded)
+		}
+	}
+}
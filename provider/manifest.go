@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/spritsail/mcbackup/backup"
+)
+
+// entryFunc is called for each file read back out of a backup. rel is the
+// slash-separated path relative to the source directory, and is empty for
+// the source directory itself. r is only readable for regular files, and
+// linkname is only set for links.
+type entryFunc func(rel string, info os.FileInfo, linkname string, r io.Reader) error
+
+// writeManifest saves a manifest to fpath using write, replacing it only once complete
+func writeManifest(fpath string, write func(w io.Writer) error) error {
+	partial := fpath + partialSuffix
+	out, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = write(out)
+	if err == nil {
+		err = out.Sync()
+	}
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(partial, fpath)
+	}
+	if err != nil {
+		os.Remove(partial)
+	}
+	return err
+}
+
+func readManifest(fpath string, read func(r io.Reader) (*backup.Manifest, error)) (*backup.Manifest, error) {
+	file, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return read(file)
+}
+
+// verifyContents reads every file in a backup using read, and compares
+// what was read to the manifest written when the backup was created
+func verifyContents(m *backup.Manifest, read func(entryFunc) error) error {
+	actual := backup.NewManifest(m.Name, m.Created)
+	err := read(func(rel string, info os.FileInfo, linkname string, r io.Reader) error {
+		if rel == "" {
+			return nil
+		}
+		_, err := actual.Add(rel, info, linkname, r)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("reading backup: %v", err)
+	}
+	actual.Finish()
+
+	return m.Verify(actual)
+}
+
+// drainContents reads every file in a backup without checking them. This at
+// least ensures that an archive can be completely read and decompressed.
+func drainContents(read func(entryFunc) error) error {
+	return read(func(rel string, info os.FileInfo, linkname string, r io.Reader) error {
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		_, err := io.Copy(ioutil.Discard, r)
+		return err
+	})
+}
@@ -0,0 +1,44 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/sirupsen/logrus"
+)
+
+// Progress tracks how much of the source has been written to a backup
+type Progress struct {
+	Files uint64
+	Bytes uint64
+}
+
+// ProgressFunc is called after each file is written to a backup
+type ProgressFunc func(Progress)
+
+// LogProgress creates a ProgressFunc that logs progress, at most once per interval
+func LogProgress(log *logrus.Entry, interval time.Duration) ProgressFunc {
+	last := time.Now()
+	return func(p Progress) {
+		if time.Since(last) < interval {
+			return
+		}
+		last = time.Now()
+		log.Infof("%d files (%s) written so far", p.Files, humanize.Bytes(p.Bytes))
+	}
+}
+
+// ctxReader is a reader that stops reading once the context is cancelled
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
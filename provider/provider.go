@@ -1,6 +1,8 @@
 package provider
 
 import (
+	"context"
+	"io"
 	"strings"
 	"time"
 
@@ -9,13 +11,50 @@ import (
 )
 
 type Provider interface {
-	Create(name string, when time.Time) (backup.Backup, error)
+	// Create takes a new backup. Cancelling the context aborts
+	// the backup, if the provider is able to do so.
+	Create(ctx context.Context, name string, when time.Time) (backup.Backup, error)
 	List() (backup.Backups, error)
+
+	// Restore extracts the contents of a backup into the dest directory.
+	// If dest is empty, the backup is restored in-place over the source
+	// after moving the live files aside.
+	Restore(bkup backup.Backup, dest string) error
+
+	// Manifest loads the manifest recorded when the backup was created
+	Manifest(bkup backup.Backup) (*backup.Manifest, error)
+
+	// Verify checks the contents of a backup against its manifest,
+	// returning a *backup.VerifyError if they differ
+	Verify(bkup backup.Backup) error
+
+	// Source is the directory that backups are taken from
+	Source() string
+
+	// Check ensures that backups can still be taken and stored
+	Check() error
+}
+
+// Opener is implemented by providers that store each backup as a single
+// file, which can be read back as-is, for example to download it
+type Opener interface {
+	// Open reads the backup, along with a suitable file name for it
+	Open(bkup backup.Backup) (io.ReadCloser, string, error)
+}
+
+// Capacity is implemented by providers that can report
+// how full the storage holding their backups is
+type Capacity interface {
+	// Capacity returns the space in use and the total space, in bytes
+	Capacity() (used uint64, total uint64, err error)
 }
 
 var allProviders = map[string]func([]string, *config.Options) (Provider, []string, error){
-	"zfs": NewZFS,
-	"tar": NewTar,
+	"zfs":  NewZFS,
+	"tar":  NewTar,
+	"zip":  NewZip,
+	"s3":   NewS3,
+	"sftp": NewSFTP,
 }
 
 func Register(name string, init func([]string, *config.Options) (Provider, []string, error)) {
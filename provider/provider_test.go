@@ -0,0 +1,37 @@
+package provider
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spritsail/mcbackup/config"
+)
+
+// newTestSource creates a temporary directory for a test, holding a server
+// directory to back up with the given files, keyed by slash-separated path.
+// It returns both directories, along with options to name backups with.
+func newTestSource(t *testing.T, files map[string]string) (dir, src string, opts *config.Options) {
+	t.Helper()
+	dir = t.TempDir()
+	src = filepath.Join(dir, "server")
+
+	err := os.MkdirAll(filepath.Join(src, "world"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		fpath := filepath.Join(src, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(fpath), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(fpath, []byte(data), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts = &config.Options{BackupPrefix: "mcb-", BackupFormat: "%F-%H:%M"}
+	return
+}
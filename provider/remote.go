@@ -0,0 +1,126 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
+	"github.com/spritsail/mcbackup/backup"
+)
+
+// remoteArchives handles tar archives stored somewhere other than the local
+// disk, such as in a bucket or on another host. Archives are written the same
+// as by the tar provider, so each remote provider only has to supply the
+// transport: opening and writing files by their key or path.
+type remoteArchives struct {
+	tar *TarProvider
+	log *logrus.Entry
+
+	open     func(name string) (io.ReadCloser, error)
+	write    func(name string, data []byte) error
+	notExist func(err error) bool
+}
+
+// hideLocalOptions stops tar provider options that only apply to a local
+// backup directory from being required or shown
+func hideLocalOptions(parser *flags.Parser, names ...string) {
+	for _, name := range names {
+		option := parser.FindOptionByLongName(name)
+		option.Required = false
+		option.Hidden = true
+	}
+}
+
+// initRemote checks the options of a tar provider whose archives are
+// stored remotely, without a local backup directory
+func (tp *TarProvider) initRemote() error {
+	tp.BackupDirectory = ""
+
+	err := checkDirectory(tp.SourceDirectory, "source")
+	if err != nil {
+		return err
+	}
+	// There is no backup directory to decrypt into by default
+	err = tp.initEncryption("")
+	if err != nil {
+		return err
+	}
+	return tp.initTar()
+}
+
+// read downloads an archive, calling fn for every file in it
+func (ra remoteArchives) read(name string, fn entryFunc) error {
+	body, err := ra.open(name)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return ra.tar.readTarStream(name, body, fn)
+}
+
+// restore extracts an archive into dest, or in place of the source
+// directory if dest is empty
+func (ra remoteArchives) restore(name, dest string) error {
+	if dest == "" {
+		_, err := moveAside(ra.tar.SourceDirectory)
+		if err != nil {
+			return err
+		}
+		dest = ra.tar.SourceDirectory
+	}
+	ra.log.WithField("archive", name).
+		WithField("dest", dest).
+		Debugf("restoring tar backup")
+
+	ex := newExtractor(dest)
+	if err := ra.read(name, ex.extract); err != nil {
+		return err
+	}
+	return ex.finish()
+}
+
+// manifest downloads the manifest stored alongside an archive
+func (ra remoteArchives) manifest(name string) (*backup.Manifest, error) {
+	in, err := ra.open(manifestName(name))
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return ra.tar.decryptManifest(manifestName(name), in)
+}
+
+// verify checks the contents of an archive against its manifest. Archives
+// without a manifest are still read in full to check they aren't truncated.
+func (ra remoteArchives) verify(bkup, name string) error {
+	read := func(fn entryFunc) error {
+		return ra.read(name, fn)
+	}
+
+	m, err := ra.manifest(name)
+	if ra.notExist(err) {
+		ra.log.WithField("backup", bkup).
+			Warn("backup has no manifest, only checking that it can be read")
+		return drainContents(read)
+	} else if err != nil {
+		return fmt.Errorf("reading manifest: %v", err)
+	}
+	return verifyContents(m, read)
+}
+
+// saveManifest uploads the manifest for a new archive alongside it. The
+// archive itself is still usable without it, so failures are only logged.
+func (ra remoteArchives) saveManifest(bkup, name string, m *backup.Manifest) {
+	m.Finish()
+	var buf bytes.Buffer
+	err := ra.tar.encryptManifest(&buf, m)
+	if err == nil {
+		err = ra.write(manifestName(name), buf.Bytes())
+	}
+	if err != nil {
+		ra.log.WithField("backup", bkup).
+			WithError(err).
+			Warn("failed to upload backup manifest")
+	}
+}
@@ -0,0 +1,103 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spritsail/mcbackup/backup"
+)
+
+// Replica is implemented by providers that can store copies of backups
+// taken by another provider, for example an offsite copy of a snapshot
+type Replica interface {
+	Provider
+
+	// Replicate copies a backup from src, keeping its name and time
+	Replicate(ctx context.Context, src Provider, bkup backup.Backup) (backup.Backup, error)
+}
+
+// archiveFunc writes an archive of the contents of dir to out, recording
+// each file in the manifest. Files are archived under the root directory.
+type archiveFunc func(ctx context.Context, out io.Writer, dir, root string, m *backup.Manifest) error
+
+// storeFunc stores a new archive with the contents written by write,
+// along with its manifest, if there is one
+type storeFunc func(write func(out io.Writer) error, m *backup.Manifest) (backup.Backup, error)
+
+// replicate copies a backup for a provider storing archives with the given
+// extension. Archives that are already stored that way are copied as-is,
+// and anything else is exported and archived again. Encrypted archives
+// copied as-is stay encrypted to the recipients they were created with.
+func replicate(ctx context.Context, src Provider, bkup backup.Backup, ext string, archive archiveFunc, store storeFunc) (backup.Backup, error) {
+	log := logrus.WithField("prefix", "replicate").
+		WithField("backup", bkup.Name())
+
+	pather, isFile := bkup.(backup.Pather)
+	opener, canOpen := src.(Opener)
+	if isFile && canOpen && strings.HasSuffix(pather.Path(), "."+ext) {
+		m, err := src.Manifest(bkup)
+		if err != nil {
+			log.WithError(err).
+				Warn("copying backup without its manifest")
+			m = nil
+		}
+
+		in, _, err := opener.Open(bkup)
+		if err != nil {
+			return nil, err
+		}
+		defer in.Close()
+
+		log.Debug("copying archive")
+		return store(func(out io.Writer) error {
+			_, err := io.Copy(out, ctxReader{ctx, in})
+			return err
+		}, m)
+	}
+
+	log.Debug("archiving backup contents")
+	m := backup.NewManifest(bkup.Name(), bkup.When())
+	root := filepath.Base(src.Source())
+
+	var copied backup.Backup
+	err := exportBackup(src, bkup, func(dir string) (err error) {
+		copied, err = store(func(out io.Writer) error {
+			return archive(ctx, out, dir, root, m)
+		}, m)
+		return
+	})
+	return copied, err
+}
+
+// exportBackup calls fn with a directory holding the contents of a backup.
+// Snapshots are read in place, and anything else is restored into a
+// temporary directory first.
+func exportBackup(src Provider, bkup backup.Backup, fn func(dir string) error) error {
+	if zp, ok := src.(*ZfsProvider); ok {
+		if snap, ok := bkup.(*zfsSnapshot); ok {
+			dir, err := zp.snapshotDir(snap)
+			if err != nil {
+				return err
+			}
+			return fn(dir)
+		}
+	}
+
+	tmp, err := ioutil.TempDir("", "mcbackup-replicate-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	err = src.Restore(bkup, tmp)
+	if err != nil {
+		return fmt.Errorf("exporting backup: %v", err)
+	}
+	return fn(tmp)
+}
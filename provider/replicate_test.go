@@ -0,0 +1,64 @@
+package provider
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spritsail/mcbackup/config"
+)
+
+func TestReplicate(t *testing.T) {
+	dir, src, opts := newTestSource(t, map[string]string{"world/level.dat": "level"})
+	newProvider := func(init func([]string, *config.Options) (Provider, []string, error), dest string) Replica {
+		prov, _, err := init([]string{"-s", src, "-b", filepath.Join(dir, dest)}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return prov.(Replica)
+	}
+	primary := newProvider(NewTar, "primary")
+
+	when := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	bkup, err := primary.Create(context.Background(), "mcb-2021-01-01-00:00", when)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same format is copied as-is, and anything else is archived again
+	for name, replica := range map[string]Replica{
+		"tar": newProvider(NewTar, "tar"),
+		"zip": newProvider(NewZip, "zip"),
+	} {
+		copied, err := replica.Replicate(context.Background(), primary, bkup)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if copied.Name() != bkup.Name() || !copied.When().Equal(when) {
+			t.Errorf("%s: copied backup is %s at %s", name, copied.Name(), copied.When())
+		}
+
+		err = replica.Verify(copied)
+		if err != nil {
+			t.Errorf("%s: verify failed: %v", name, err)
+		}
+
+		dest := filepath.Join(dir, "restored-"+name)
+		err = replica.Restore(copied, dest)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		restored, err := ioutil.ReadFile(filepath.Join(dest, "world", "level.dat"))
+		if err != nil || string(restored) != "level" {
+			t.Errorf("%s: restored file doesn't match the original: %q %v", name, restored, err)
+		}
+	}
+
+	original, _ := ioutil.ReadFile(filepath.Join(dir, "primary", "mcb-2021-01-01-00:00.tar.gz"))
+	copied, _ := ioutil.ReadFile(filepath.Join(dir, "tar", "mcb-2021-01-01-00:00.tar.gz"))
+	if string(original) != string(copied) {
+		t.Errorf("tar archive should be copied as-is")
+	}
+}
@@ -0,0 +1,270 @@
+package provider
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// asidePrefix names the directory that live files are moved into
+// before a backup is restored in-place over the top of them
+const asidePrefix = ".mcbackup-restore-"
+
+// stripTopLevel removes the leading top-level folder from an archive entry
+// name, as archives are rooted at the name of the source directory.
+// An empty string is returned for the top-level folder itself.
+func stripTopLevel(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if idx := strings.IndexByte(name, '/'); idx >= 0 {
+		return name[idx+1:]
+	}
+	return ""
+}
+
+// extractor writes the files read from a backup into the dest directory.
+// Directories are kept writable until everything has been extracted, as
+// a read-only directory couldn't be restored into otherwise.
+type extractor struct {
+	dest string
+	dirs []extractedDir
+}
+
+// extractedDir is a directory whose permissions and modification time
+// are applied once extraction has finished
+type extractedDir struct {
+	path    string
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newExtractor(dest string) *extractor {
+	return &extractor{dest: dest}
+}
+
+// finish applies the permissions and modification times of the extracted
+// directories, deepest first so that a read-only parent doesn't stop its
+// children from being changed
+func (ex *extractor) finish() error {
+	sort.SliceStable(ex.dirs, func(i, j int) bool {
+		return strings.Count(ex.dirs[i].path, string(filepath.Separator)) >
+			strings.Count(ex.dirs[j].path, string(filepath.Separator))
+	})
+	for _, dir := range ex.dirs {
+		err := os.Chmod(dir.path, dir.mode)
+		if err == nil {
+			err = os.Chtimes(dir.path, time.Now(), dir.modTime)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extract writes a single file from a backup to the path rel within the
+// dest directory. r is only read for regular files, and linkname is only
+// used for symbolic and hard links.
+func (ex *extractor) extract(rel string, info os.FileInfo, linkname string, r io.Reader) (err error) {
+	dest := ex.dest
+
+	// Refuse anything that would escape the destination directory
+	rel = path.Clean("/" + filepath.ToSlash(rel))
+	if rel == "/" {
+		return nil
+	}
+	target := filepath.Join(dest, filepath.FromSlash(rel))
+
+	// Symlinks already restored could redirect writes outside of dest
+	err = checkParents(dest, rel)
+	if err != nil {
+		return
+	}
+	if fi, err := os.Lstat(target); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		err = os.Remove(target)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return
+	}
+
+	switch mode := info.Mode(); {
+	case mode.IsDir():
+		err = os.MkdirAll(target, 0755)
+		if err == nil {
+			// Existing directories must be writable to restore into too
+			err = os.Chmod(target, mode.Perm()|0700)
+		}
+		if err != nil {
+			return
+		}
+		ex.dirs = append(ex.dirs, extractedDir{path: target, mode: mode.Perm(), modTime: info.ModTime()})
+		return nil
+
+	case mode&os.ModeSymlink != 0:
+		if escapes(path.Join(path.Dir(rel[1:]), linkname)) || path.IsAbs(linkname) {
+			return fmt.Errorf("%s: refusing to restore symlink to %s outside of the backup", rel, linkname)
+		}
+		os.Remove(target)
+		return os.Symlink(linkname, target)
+
+	case linkname != "":
+		// Hard links are relative to the archive root, same as the entry itself
+		if escapes(linkname) || path.IsAbs(linkname) {
+			return fmt.Errorf("%s: refusing to restore hard link to %s outside of the backup", rel, linkname)
+		}
+		err = checkParents(dest, "/"+linkname)
+		if err != nil {
+			return
+		}
+		os.Remove(target)
+		return os.Link(filepath.Join(dest, filepath.FromSlash(path.Clean(linkname))), target)
+
+	case mode.IsRegular():
+		var out *os.File
+		out, err = os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+		if err != nil {
+			return
+		}
+		_, err = io.Copy(out, r)
+		if e := out.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			return fmt.Errorf("%s: writing contents: %v", rel, err)
+		}
+
+	default:
+		logrus.WithField("prefix", "restore").
+			WithField("file", rel).
+			Debug("skipping irregular file")
+		return nil
+	}
+	if err != nil {
+		return
+	}
+
+	return os.Chtimes(target, time.Now(), info.ModTime())
+}
+
+// escapes reports whether a relative slash-separated path leads outside of
+// the directory it is relative to
+func escapes(rel string) bool {
+	rel = path.Clean(rel)
+	return rel == ".." || strings.HasPrefix(rel, "../")
+}
+
+// checkParents ensures that none of the directories leading to the path rel
+// within dest are symlinks, which would otherwise be followed when writing
+func checkParents(dest, rel string) error {
+	dir := dest
+	parts := strings.Split(strings.Trim(path.Dir(rel), "/"), "/")
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s: refusing to restore through symlink %s", rel, part)
+		}
+	}
+	return nil
+}
+
+// rename moves files aside, and can be replaced by tests to make it fail
+var rename = os.Rename
+
+// moveAside moves all files in dir into a new hidden directory within it,
+// leaving dir empty for a backup to be restored into. Any previously moved
+// aside directories, and any paths listed in keep, are left untouched. If a
+// file can't be moved, such as a mountpoint, everything is moved back.
+func moveAside(dir string, keep ...string) (aside string, err error) {
+	log := logrus.WithField("prefix", "restore")
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	keepAbs := make(map[string]bool, len(keep))
+	for _, k := range keep {
+		abs, err := filepath.Abs(k)
+		if err != nil {
+			return "", err
+		}
+		keepAbs[abs] = true
+	}
+
+	aside = filepath.Join(dir, asidePrefix+time.Now().Format("20060102-150405"))
+	err = os.Mkdir(aside, 0700)
+	if err != nil {
+		return
+	}
+
+	var moved []string
+	for _, info := range infos {
+		name := info.Name()
+		fpath := filepath.Join(dir, name)
+		abs, err := filepath.Abs(fpath)
+		if err == nil && (name == ".zfs" || strings.HasPrefix(name, asidePrefix) || keepAbs[abs]) {
+			continue
+		}
+
+		if err == nil {
+			log.Tracef("moving %s aside", name)
+			err = rename(fpath, filepath.Join(aside, name))
+		}
+		if err != nil {
+			return "", moveBack(dir, aside, moved, err)
+		}
+		moved = append(moved, name)
+	}
+
+	log.Infof("moved live files aside to %s", aside)
+	return
+}
+
+// moveBack undoes moving files aside after moving one failed, so that the
+// live files are left as they were. The error is returned, describing
+// whether the files could all be moved back.
+func moveBack(dir, aside string, moved []string, err error) error {
+	for i := len(moved) - 1; i >= 0; i-- {
+		e := rename(filepath.Join(aside, moved[i]), filepath.Join(dir, moved[i]))
+		if e != nil {
+			return fmt.Errorf("moving files aside: %v, and failed to move them back from %s: %v", err, aside, e)
+		}
+	}
+	os.Remove(aside)
+	return &UnchangedError{Err: fmt.Errorf("moving files aside: %v", err)}
+}
+
+// UnchangedError is returned by an in-place restore that failed before
+// any live files were changed, so the server can carry on as it was
+type UnchangedError struct {
+	Err error
+}
+
+func (ue *UnchangedError) Error() string {
+	return ue.Err.Error() + ", no files were changed"
+}
+
+func (ue *UnchangedError) Unwrap() error {
+	return ue.Err
+}
@@ -0,0 +1,194 @@
+package provider
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spritsail/mcbackup/config"
+)
+
+func TestStripTopLevel(t *testing.T) {
+	var tests = map[string]string{
+		"world":                   "",
+		"world/":                  "",
+		"world/level.dat":         "level.dat",
+		"world/region/r.0.0.mca":  "region/r.0.0.mca",
+		"/world/../../etc/passwd": "passwd",
+	}
+	for name, expected := range tests {
+		out := stripTopLevel(name)
+		if out != expected {
+			t.Errorf("stripTopLevel(\"%s\") -> %s, should be %s",
+				name, out, expected)
+		}
+	}
+}
+
+func TestRestoreEscape(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcbackup-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backupDir := filepath.Join(dir, "backups")
+	outside := filepath.Join(dir, "outside")
+	for _, d := range []string{filepath.Join(dir, "server"), backupDir, outside} {
+		err = os.Mkdir(d, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each archive plants a symlink, then tries to write through it
+	for name, link := range map[string]string{
+		"absolute": outside,
+		"relative": "../../outside",
+	} {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gw)
+		for _, hdr := range []*tar.Header{
+			{Name: "server/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "server/world", Typeflag: tar.TypeSymlink, Linkname: link, Mode: 0777},
+			{Name: "server/world/ops.json", Typeflag: tar.TypeReg, Mode: 0644, Size: 4},
+		} {
+			tw.WriteHeader(hdr)
+			if hdr.Typeflag == tar.TypeReg {
+				tw.Write([]byte("evil"))
+			}
+		}
+		tw.Close()
+		gw.Close()
+
+		archive := filepath.Join(backupDir, "mcb-2021-01-01-00:00.tar.gz")
+		err = ioutil.WriteFile(archive, buf.Bytes(), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts := &config.Options{BackupPrefix: "mcb-", BackupFormat: "%F-%H:%M"}
+		prov, _, err := NewTar([]string{"-s", filepath.Join(dir, "server"), "-b", backupDir}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bkups, err := prov.List()
+		if err != nil || len(bkups) != 1 {
+			t.Fatalf("%s: List() -> %v, %v", name, bkups, err)
+		}
+
+		err = prov.Restore(bkups[0], filepath.Join(dir, "restore-"+name))
+		if err == nil {
+			t.Errorf("%s: restored a symlink leading outside of the backup", name)
+		}
+		if _, err := os.Stat(filepath.Join(outside, "ops.json")); err == nil {
+			t.Fatalf("%s: file written outside of the restore directory", name)
+		}
+		os.Remove(archive)
+	}
+}
+
+func TestRestoreThroughSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcbackup-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A symlink already in the destination is never written through
+	dest := filepath.Join(dir, "dest")
+	outside := filepath.Join(dir, "outside")
+	os.Mkdir(dest, 0755)
+	os.Mkdir(outside, 0755)
+	err = os.Symlink(outside, filepath.Join(dest, "world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := (&tar.Header{Name: "ops.json", Typeflag: tar.TypeReg, Mode: 0644}).FileInfo()
+	err = newExtractor(dest).extract("world/ops.json", info, "", strings.NewReader("evil"))
+	if err == nil {
+		t.Error("restored a file through a symlink")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "ops.json")); err == nil {
+		t.Error("file written outside of the restore directory")
+	}
+}
+
+func TestMoveAsideFails(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Moving b fails, such as if it were a mountpoint
+	defer func() { rename = os.Rename }()
+	rename = func(from, to string) error {
+		if filepath.Base(from) == "b" && filepath.Dir(from) == dir {
+			return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EBUSY}
+		}
+		return os.Rename(from, to)
+	}
+
+	_, err := moveAside(dir)
+	if _, ok := err.(*UnchangedError); !ok {
+		t.Fatalf("expected moving files aside to fail without changing anything, got %v", err)
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	if strings.Join(names, ",") != "a,b,c" {
+		t.Errorf("expected the files to be moved back, found %v", names)
+	}
+}
+
+func TestRestoreReadOnlyDirectory(t *testing.T) {
+	dest := t.TempDir()
+	modTime := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Read-only directories are still restored into, and keep their mode
+	ex := newExtractor(dest)
+	for _, hdr := range []*tar.Header{
+		{Name: "world", Typeflag: tar.TypeDir, Mode: 0555, ModTime: modTime},
+		{Name: "world/data", Typeflag: tar.TypeDir, Mode: 0500, ModTime: modTime},
+		{Name: "world/data/level.dat", Typeflag: tar.TypeReg, Mode: 0444, ModTime: modTime},
+	} {
+		err := ex.extract(hdr.Name, hdr.FileInfo(), "", strings.NewReader("level"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := ex.finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(dest, "world", "data"), 0755)
+	defer os.Chmod(filepath.Join(dest, "world"), 0755)
+
+	for name, mode := range map[string]os.FileMode{"world": 0555, "world/data": 0500} {
+		info, err := os.Stat(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != mode || !info.ModTime().Equal(modTime) {
+			t.Errorf("%s restored with mode %v at %s, should be %v at %s",
+				name, info.Mode().Perm(), info.ModTime(), mode, modTime)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
+	"github.com/spritsail/mcbackup/backup"
+	"github.com/spritsail/mcbackup/config"
+)
+
+// S3Provider streams tar archives of the source directory to a bucket on S3,
+// or any compatible object storage such as MinIO, without a local copy
+type S3Provider struct {
+	opts   *config.Options
+	client *s3.S3
+
+	// All tar provider options apply, except those for the local backup directory
+	Tar TarProvider
+
+	Bucket    string `long:"s3-bucket" description:"Bucket to store backups in" env:"S3_BUCKET" required:"true"`
+	Prefix    string `long:"s3-prefix" description:"Prefix added to the key of each backup, such as a directory ending in /" env:"S3_PREFIX"`
+	Endpoint  string `long:"s3-endpoint" description:"URL of S3-compatible storage, such as a MinIO server. Defaults to AWS" env:"S3_ENDPOINT"`
+	Region    string `long:"s3-region" description:"Region the bucket is in" env:"S3_REGION" default:"us-east-1"`
+	AccessKey string `long:"s3-access-key" description:"Access key ID. If unspecified, the usual AWS environment variables and credential files are used" env:"S3_ACCESS_KEY"`
+	SecretKey string `long:"s3-secret-key" description:"Secret access key" env:"S3_SECRET_KEY" default-mask:"-"`
+	PathStyle bool   `long:"s3-path-style" description:"Address the bucket in the URL path rather than as a subdomain, as most S3-compatible storage requires" env:"S3_PATH_STYLE"`
+	PartSize  uint   `long:"s3-part-size" description:"Size of each part uploaded, in MiB. Backups can be up to 10000 parts" env:"S3_PART_SIZE" default:"16"`
+}
+
+func NewS3(args []string, opts *config.Options) (p Provider, remain []string, err error) {
+	var s3Opts S3Provider
+	s3Opts.opts = opts
+	s3Opts.Tar.opts = opts
+
+	parser := flags.NewParser(&s3Opts, flags.IgnoreUnknown)
+
+	// Incomplete uploads are never left behind, so there's nothing to clean
+	hideLocalOptions(parser, "backup-dir", "clean-partial")
+
+	err = opts.ApplyConfig(parser, "s3")
+	if err != nil {
+		return
+	}
+	remain, err = parser.ParseArgs(args)
+	if err != nil {
+		return
+	}
+
+	if int64(s3Opts.PartSize)*1024*1024 < s3manager.MinUploadPartSize {
+		err = fmt.Errorf("S3 part size must be at least %d MiB", s3manager.MinUploadPartSize/1024/1024)
+		return
+	}
+
+	err = s3Opts.Tar.initRemote()
+	if err != nil {
+		return
+	}
+
+	cfg := aws.NewConfig().
+		WithRegion(s3Opts.Region).
+		WithS3ForcePathStyle(s3Opts.PathStyle)
+	if s3Opts.Endpoint != "" {
+		cfg = cfg.WithEndpoint(s3Opts.Endpoint)
+	}
+	if s3Opts.AccessKey != "" {
+		cfg = cfg.WithCredentials(credentials.NewStaticCredentials(s3Opts.AccessKey, s3Opts.SecretKey, ""))
+	}
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return
+	}
+	s3Opts.client = s3.New(sess)
+
+	err = s3Opts.Check()
+	if err != nil {
+		return
+	}
+
+	p = &s3Opts
+	return
+}
+
+func (sp *S3Provider) logger() *logrus.Entry {
+	return logrus.WithField("prefix", "s3").
+		WithField("bucket", sp.Bucket)
+}
+
+// archives reads and writes objects in the bucket by their key
+func (sp *S3Provider) archives(ctx context.Context) remoteArchives {
+	return remoteArchives{
+		tar: &sp.Tar,
+		log: sp.logger(),
+		open: func(key string) (io.ReadCloser, error) {
+			obj, err := sp.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+				Bucket: aws.String(sp.Bucket),
+				Key:    aws.String(key),
+			})
+			if err != nil {
+				return nil, err
+			}
+			return obj.Body, nil
+		},
+		write: func(key string, data []byte) error {
+			_, err := sp.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+				Bucket:      aws.String(sp.Bucket),
+				Key:         aws.String(key),
+				Body:        bytes.NewReader(data),
+				ContentType: aws.String(manifestType(key)),
+			})
+			return err
+		},
+		notExist: isNotFound,
+	}
+}
+
+// Create streams a new tar archive to the bucket. The upload is only
+// completed once the whole archive is written, so failed backups never
+// leave an incomplete object behind.
+func (sp *S3Provider) Create(ctx context.Context, name string, when time.Time) (backup.Backup, error) {
+	manifest := backup.NewManifest(name, when)
+	return sp.upload(ctx, name, when, func(out io.Writer) error {
+		return sp.Tar.writeTar(ctx, out, manifest)
+	}, manifest)
+}
+
+// Replicate uploads a copy of a backup from another provider
+func (sp *S3Provider) Replicate(ctx context.Context, src Provider, bkup backup.Backup) (backup.Backup, error) {
+	return replicate(ctx, src, bkup, sp.Tar.fileExt(), sp.Tar.archiveDir,
+		func(write func(out io.Writer) error, m *backup.Manifest) (backup.Backup, error) {
+			return sp.upload(ctx, bkup.Name(), bkup.When(), write, m)
+		})
+}
+
+// upload streams the archive written by write to the bucket, along with
+// its manifest, if there is one
+func (sp *S3Provider) upload(ctx context.Context, name string, when time.Time, write func(out io.Writer) error, m *backup.Manifest) (backup.Backup, error) {
+	key := sp.Prefix + name + "." + sp.Tar.fileExt()
+	sp.logger().WithField("key", key).Debug("uploading tar backup")
+
+	pr, pw := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		err := write(pw)
+		pw.CloseWithError(err)
+		written <- err
+	}()
+
+	uploader := s3manager.NewUploaderWithClient(sp.client, func(u *s3manager.Uploader) {
+		u.PartSize = int64(sp.PartSize) * 1024 * 1024
+	})
+	_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(sp.Bucket),
+		Key:         aws.String(key),
+		Body:        pr,
+		ContentType: aws.String("application/x-tar"),
+	})
+
+	// Stop writing the archive if the upload failed
+	pr.CloseWithError(err)
+	if e := <-written; e != nil {
+		err = e
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bkup := &s3Backup{
+		provider: sp,
+		key:      key,
+		name:     name,
+		when:     when,
+		reason:   backup.Unknown,
+	}
+	head, err := sp.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(sp.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	bkup.size = uint64(aws.Int64Value(head.ContentLength))
+
+	if m != nil {
+		sp.archives(ctx).saveManifest(name, key, m)
+	}
+	return bkup, nil
+}
+
+// manifestType is the content type of a manifest, which is only JSON if it
+// isn't encrypted
+func manifestType(key string) string {
+	if isEncrypted(key) {
+		return "application/octet-stream"
+	}
+	return "application/json"
+}
+
+// List finds all mcbackup-managed archives under the prefix in the bucket
+func (sp *S3Provider) List() (backup.Backups, error) {
+	var bkups backup.Backups
+	var err error
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(sp.Bucket),
+		Prefix: aws.String(sp.Prefix),
+	}
+	pageErr := sp.client.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range page.Contents {
+			key := aws.StringValue(obj.Key)
+			name := strings.TrimPrefix(key, sp.Prefix)
+			if strings.Contains(name, "/") || !sp.opts.IsMcbackup(name) ||
+				!hasArchiveExt(name, sp.Tar.Extension) {
+				continue
+			}
+
+			var when time.Time
+			when, err = sp.opts.ParseBackupName(name)
+			if err != nil {
+				return false
+			}
+			// Recreate the backup name from the parsed value, without the extension
+			name, err = sp.opts.GenBackupName(when)
+			if err != nil {
+				return false
+			}
+
+			bkups = append(bkups, &s3Backup{
+				provider: sp,
+				key:      key,
+				name:     name,
+				when:     when,
+				size:     uint64(aws.Int64Value(obj.Size)),
+				reason:   backup.Unknown,
+			})
+		}
+		return true
+	})
+	if pageErr != nil {
+		return nil, pageErr
+	}
+	return bkups, err
+}
+
+func (sp *S3Provider) Restore(bkup backup.Backup, dest string) error {
+	sb, ok := bkup.(*s3Backup)
+	if !ok {
+		return fmt.Errorf("backup %s is not stored in S3", bkup.Name())
+	}
+	return sp.archives(context.Background()).restore(sb.key, dest)
+}
+
+// Manifest downloads the manifest uploaded alongside a backup
+func (sp *S3Provider) Manifest(bkup backup.Backup) (*backup.Manifest, error) {
+	sb, ok := bkup.(*s3Backup)
+	if !ok {
+		return nil, fmt.Errorf("backup %s is not stored in S3", bkup.Name())
+	}
+	return sp.archives(context.Background()).manifest(sb.key)
+}
+
+func (sp *S3Provider) Verify(bkup backup.Backup) error {
+	sb, ok := bkup.(*s3Backup)
+	if !ok {
+		return fmt.Errorf("backup %s is not stored in S3", bkup.Name())
+	}
+	return sp.archives(context.Background()).verify(sb.name, sb.key)
+}
+
+// Open downloads the archive of a backup
+func (sp *S3Provider) Open(bkup backup.Backup) (io.ReadCloser, string, error) {
+	sb, ok := bkup.(*s3Backup)
+	if !ok {
+		return nil, "", fmt.Errorf("backup %s is not stored in S3", bkup.Name())
+	}
+	body, err := sp.archives(context.Background()).open(sb.key)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, path.Base(sb.key), nil
+}
+
+func (sp *S3Provider) Source() string {
+	return sp.Tar.SourceDirectory
+}
+
+// Check ensures the bucket can still be reached
+func (sp *S3Provider) Check() error {
+	_, err := sp.client.HeadBucket(&s3.HeadBucketInput{
+		Bucket: aws.String(sp.Bucket),
+	})
+	if err != nil {
+		return fmt.Errorf("bucket %s: %v", sp.Bucket, err)
+	}
+	return nil
+}
+
+func isNotFound(err error) bool {
+	if aerr, ok := err.(awserr.RequestFailure); ok {
+		return aerr.StatusCode() == 404
+	}
+	return false
+}
+
+var _ Provider = &S3Provider{}
+var _ Opener = &S3Provider{}
+var _ Replica = &S3Provider{}
@@ -0,0 +1,216 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 stores objects in memory, implementing just enough of the S3
+// API with path-style addressing for the provider to work against it
+type fakeS3 struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+
+	// Number of multipart uploads completed
+	completed int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if parts[0] != f.bucket {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	has := func(param string) bool {
+		_, ok := query[param]
+		return ok
+	}
+
+	// Bucket operations
+	if len(parts) == 1 || parts[1] == "" {
+		switch r.Method {
+		case http.MethodHead:
+		case http.MethodGet:
+			type object struct {
+				Key  string
+				Size int
+			}
+			var result struct {
+				XMLName  xml.Name `xml:"ListBucketResult"`
+				KeyCount int
+				Contents []object
+			}
+			for key, data := range f.objects {
+				if strings.HasPrefix(key, query.Get("prefix")) {
+					result.Contents = append(result.Contents, object{key, len(data)})
+				}
+			}
+			sort.Slice(result.Contents, func(i, j int) bool {
+				return result.Contents[i].Key < result.Contents[j].Key
+			})
+			result.KeyCount = len(result.Contents)
+			xml.NewEncoder(w).Encode(result)
+		default:
+			http.Error(w, "", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	key := parts[1]
+	body, _ := ioutil.ReadAll(r.Body)
+	switch {
+	case r.Method == http.MethodPost && has("uploads"):
+		id := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[id] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>",
+			f.bucket, key, id)
+
+	case r.Method == http.MethodPut && has("uploadId"):
+		n, _ := strconv.Atoi(query.Get("partNumber"))
+		f.uploads[query.Get("uploadId")][n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, n))
+
+	case r.Method == http.MethodPost && has("uploadId"):
+		upload := f.uploads[query.Get("uploadId")]
+		var data []byte
+		for n := 1; n <= len(upload); n++ {
+			data = append(data, upload[n]...)
+		}
+		f.objects[key] = data
+		delete(f.uploads, query.Get("uploadId"))
+		f.completed++
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key></CompleteMultipartUploadResult>", key)
+
+	case r.Method == http.MethodDelete && has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+
+	case r.Method == http.MethodHead, r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "", http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3RoundTrip(t *testing.T) {
+	// Random data doesn't compress, so this is uploaded in several parts
+	region := make([]byte, 6*1024*1024)
+	rand.Read(region)
+
+	dir, src, opts := newTestSource(t, map[string]string{
+		"world/r.0.0.mca":   string(region),
+		"server.properties": "motd=hi",
+	})
+
+	fake := &fakeS3{
+		bucket:  "minecraft",
+		objects: make(map[string][]byte),
+		uploads: make(map[string]map[int][]byte),
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	prov, remain, err := NewS3([]string{
+		"-s", src,
+		"--s3-bucket", "minecraft",
+		"--s3-prefix", "backups/",
+		"--s3-endpoint", server.URL,
+		"--s3-path-style",
+		"--s3-access-key", "access",
+		"--s3-secret-key", "secret",
+		"--s3-part-size", "5",
+	}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remain) != 0 {
+		t.Fatalf("unexpected remaining args %v", remain)
+	}
+
+	when := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	bkup, err := prov.Create(context.Background(), "mcb-2021-01-01-00:00", when)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.objects["backups/mcb-2021-01-01-00:00.tar.gz.manifest.json"]; !ok {
+		t.Errorf("manifest wasn't uploaded, objects: %v", fake.objects)
+	}
+	if fake.completed != 1 || len(fake.uploads) != 0 {
+		t.Errorf("expected a single complete multipart upload, %d completed and %d left",
+			fake.completed, len(fake.uploads))
+	}
+
+	backups, err := prov.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Name() != bkup.Name() || !backups[0].When().Equal(when) {
+		t.Fatalf("expected to list the backup, got %v", backups)
+	}
+	size, _ := backups[0].Size()
+	if size != uint64(len(fake.objects["backups/mcb-2021-01-01-00:00.tar.gz"])) {
+		t.Errorf("listed size %d doesn't match the object", size)
+	}
+
+	err = prov.Verify(backups[0])
+	if err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+
+	dest := filepath.Join(dir, "restored")
+	err = prov.Restore(backups[0], dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := ioutil.ReadFile(filepath.Join(dest, "world", "r.0.0.mca"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restored, region) {
+		t.Errorf("restored region file doesn't match the original")
+	}
+
+	err = backups[0].Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.objects) != 0 {
+		t.Errorf("objects left after deleting the backup: %d", len(fake.objects))
+	}
+}
@@ -0,0 +1,75 @@
+package provider
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/spritsail/mcbackup/backup"
+)
+
+type s3Backup struct {
+	provider *S3Provider
+	key      string
+	size     uint64
+
+	name   string
+	when   time.Time
+	reason backup.Reason
+}
+
+func (sb *s3Backup) Name() string {
+	return sb.name
+}
+
+func (sb *s3Backup) When() time.Time {
+	return sb.when
+}
+
+func (sb *s3Backup) Path() string {
+	return "s3://" + sb.provider.Bucket + "/" + sb.key
+}
+
+func (sb *s3Backup) manifestKey() string {
+	return manifestName(sb.key)
+}
+
+// Delete removes the backup and its manifest from the bucket. Deleting
+// an object that doesn't exist succeeds, so no manifest is fine.
+func (sb *s3Backup) Delete() error {
+	client := sb.provider.client
+	for _, key := range []string{sb.key, sb.manifestKey()} {
+		_, err := client.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(sb.provider.Bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Size is the size of the object, as listed in the bucket
+func (sb *s3Backup) Size() (uint64, error) {
+	return sb.size, nil
+}
+
+func (sb *s3Backup) SpaceUsed() (uint64, error) {
+	return sb.size, nil
+}
+
+func (sb *s3Backup) Reason() backup.Reason {
+	return sb.reason
+}
+
+func (sb *s3Backup) AddReason(r backup.Reason) {
+	sb.reason |= r
+}
+
+func (sb *s3Backup) SetReason(r backup.Reason) {
+	sb.reason = r
+}
+
+var _ backup.Backup = &s3Backup{}
+var _ backup.Pather = &s3Backup{}
@@ -0,0 +1,450 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+	"github.com/spritsail/mcbackup/backup"
+	"github.com/spritsail/mcbackup/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPProvider streams tar archives of the source directory to a directory
+// on a remote host over SSH, without a local copy
+type SFTPProvider struct {
+	opts *config.Options
+	ssh  *ssh.ClientConfig
+
+	// The connection is opened again if it drops between backups
+	mu     sync.Mutex
+	client *sftp.Client
+
+	// Incomplete uploads are cleaned up as for the tar provider, whose
+	// other options also apply, except the local backup directory
+	Tar TarProvider
+
+	Host          string        `long:"sftp-host" description:"Host to store backups on" env:"SFTP_HOST" required:"true"`
+	Port          uint          `long:"sftp-port" description:"SSH port of the host" env:"SFTP_PORT" default:"22"`
+	User          string        `long:"sftp-user" description:"User to log in as" env:"SFTP_USER" required:"true"`
+	Directory     string        `long:"sftp-dir" description:"Remote directory to save backup archives, relative to the home directory unless absolute" env:"SFTP_DIRECTORY" required:"true"`
+	KeyFile       string        `long:"sftp-key" description:"Private key file to log in with" env:"SFTP_KEY"`
+	KeyPassphrase string        `long:"sftp-key-passphrase" description:"Passphrase of the private key, if it is encrypted" env:"SFTP_KEY_PASSPHRASE" default-mask:"-"`
+	Password      string        `long:"sftp-password" description:"Password to log in with, if the host accepts it" env:"SFTP_PASSWORD" default-mask:"-"`
+	KnownHosts    string        `long:"sftp-known-hosts" description:"known_hosts file used to verify the key of the host" env:"SFTP_KNOWN_HOSTS" default:"~/.ssh/known_hosts"`
+	Timeout       time.Duration `long:"sftp-timeout" description:"Time allowed to connect to the host" env:"SFTP_TIMEOUT" default:"30s"`
+}
+
+func NewSFTP(args []string, opts *config.Options) (p Provider, remain []string, err error) {
+	var sftpOpts SFTPProvider
+	sftpOpts.opts = opts
+	sftpOpts.Tar.opts = opts
+
+	parser := flags.NewParser(&sftpOpts, flags.IgnoreUnknown)
+
+	hideLocalOptions(parser, "backup-dir")
+
+	err = opts.ApplyConfig(parser, "sftp")
+	if err != nil {
+		return
+	}
+	remain, err = parser.ParseArgs(args)
+	if err != nil {
+		return
+	}
+
+	err = sftpOpts.Tar.initRemote()
+	if err != nil {
+		return
+	}
+
+	sftpOpts.ssh, err = sftpOpts.clientConfig()
+	if err != nil {
+		return
+	}
+	err = sftpOpts.Check()
+	if err != nil {
+		return
+	}
+
+	p = &sftpOpts
+	return
+}
+
+// clientConfig sets up authentication and verification of the host key
+func (sp *SFTPProvider) clientConfig() (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+	if sp.KeyFile != "" {
+		pem, err := ioutil.ReadFile(expandHome(sp.KeyFile))
+		if err != nil {
+			return nil, err
+		}
+		var signer ssh.Signer
+		if sp.KeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(sp.KeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(pem)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("private key %s: %v", sp.KeyFile, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if sp.Password != "" {
+		auth = append(auth, ssh.Password(sp.Password))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("either a private key or a password is required to log in to %s", sp.Host)
+	}
+
+	hostKeys, err := knownhosts.New(expandHome(sp.KnownHosts))
+	if err != nil {
+		return nil, fmt.Errorf("known hosts: %v", err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            sp.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         sp.Timeout,
+	}, nil
+}
+
+// expandHome replaces a leading ~ with the home directory of the current user
+func expandHome(fpath string) string {
+	if fpath != "~" && !strings.HasPrefix(fpath, "~/") {
+		return fpath
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fpath
+	}
+	return filepath.Join(home, fpath[1:])
+}
+
+func (sp *SFTPProvider) logger() *logrus.Entry {
+	return logrus.WithField("prefix", "sftp").
+		WithField("host", sp.Host)
+}
+
+// archives reads and writes files in the remote directory by their path
+func (sp *SFTPProvider) archives() remoteArchives {
+	return remoteArchives{
+		tar: &sp.Tar,
+		log: sp.logger(),
+		open: func(fpath string) (io.ReadCloser, error) {
+			client, err := sp.connect()
+			if err != nil {
+				return nil, err
+			}
+			return client.Open(fpath)
+		},
+		write: func(fpath string, data []byte) error {
+			client, err := sp.connect()
+			if err != nil {
+				return err
+			}
+			out, err := client.Create(fpath)
+			if err != nil {
+				return err
+			}
+			_, err = out.Write(data)
+			if e := out.Close(); err == nil {
+				err = e
+			}
+			return err
+		},
+		notExist: os.IsNotExist,
+	}
+}
+
+// connect returns the open connection to the host, or opens a new one
+func (sp *SFTPProvider) connect() (*sftp.Client, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.client != nil {
+		return sp.client, nil
+	}
+
+	addr := net.JoinHostPort(sp.Host, strconv.Itoa(int(sp.Port)))
+	sp.logger().Debug("connecting")
+	conn, err := ssh.Dial("tcp", addr, sp.ssh)
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// Forget the connection once it is closed, so the next use reconnects
+	go func() {
+		conn.Wait()
+		client.Close()
+		sp.mu.Lock()
+		if sp.client == client {
+			sp.client = nil
+		}
+		sp.mu.Unlock()
+	}()
+
+	sp.client = client
+	return client, nil
+}
+
+// Close disconnects from the host
+func (sp *SFTPProvider) Close() error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.client == nil {
+		return nil
+	}
+	err := sp.client.Close()
+	sp.client = nil
+	return err
+}
+
+// Create streams a new tar archive to the remote directory
+func (sp *SFTPProvider) Create(ctx context.Context, name string, when time.Time) (backup.Backup, error) {
+	manifest := backup.NewManifest(name, when)
+	return sp.upload(ctx, name, when, func(out io.Writer) error {
+		return sp.Tar.writeTar(ctx, out, manifest)
+	}, manifest)
+}
+
+// Replicate uploads a copy of a backup from another provider
+func (sp *SFTPProvider) Replicate(ctx context.Context, src Provider, bkup backup.Backup) (backup.Backup, error) {
+	return replicate(ctx, src, bkup, sp.Tar.fileExt(), sp.Tar.archiveDir,
+		func(write func(out io.Writer) error, m *backup.Manifest) (backup.Backup, error) {
+			return sp.upload(ctx, bkup.Name(), bkup.When(), write, m)
+		})
+}
+
+// upload streams the archive written by write to the remote directory,
+// along with its manifest, if there is one. The archive is written under
+// a partial name and renamed into place once complete, so that an
+// incomplete archive is never mistaken for a complete backup.
+func (sp *SFTPProvider) upload(ctx context.Context, name string, when time.Time, write func(out io.Writer) error, m *backup.Manifest) (backup.Backup, error) {
+	client, err := sp.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	fpath := path.Join(sp.Directory, name+"."+sp.Tar.fileExt())
+	partial := fpath + partialSuffix
+	sp.logger().WithField("path", fpath).Debug("uploading tar backup")
+
+	if _, err = client.Lstat(fpath); err == nil {
+		return nil, fmt.Errorf("file already exists: %s", fpath)
+	}
+
+	out, err := client.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Closing the connection is the only way to interrupt a blocked write
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			sp.Close()
+		case <-stop:
+		}
+	}()
+
+	err = write(out)
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = client.Rename(partial, fpath)
+	}
+	if err != nil {
+		// Don't leave a broken archive lying around
+		client.Remove(partial)
+		return nil, err
+	}
+
+	bkup := &sftpBackup{
+		provider: sp,
+		path:     fpath,
+		name:     name,
+		when:     when,
+		reason:   backup.Unknown,
+	}
+	info, err := client.Stat(fpath)
+	if err != nil {
+		return nil, err
+	}
+	bkup.size = uint64(info.Size())
+
+	if m != nil {
+		sp.archives().saveManifest(name, fpath, m)
+	}
+	return bkup, nil
+}
+
+// List finds all mcbackup-managed archives in the remote directory
+func (sp *SFTPProvider) List() (backup.Backups, error) {
+	client, err := sp.connect()
+	if err != nil {
+		return nil, err
+	}
+	infos, err := client.ReadDir(sp.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var bkups backup.Backups
+	for _, info := range infos {
+		if !sp.opts.IsMcbackup(info.Name()) {
+			continue
+		}
+		if strings.HasSuffix(info.Name(), partialSuffix) &&
+			hasArchiveExt(strings.TrimSuffix(info.Name(), partialSuffix), sp.Tar.Extension) {
+			sp.cleanPartial(client, info)
+			continue
+		}
+		if !hasArchiveExt(info.Name(), sp.Tar.Extension) {
+			continue
+		}
+
+		when, err := sp.opts.ParseBackupName(info.Name())
+		if err != nil {
+			return nil, err
+		}
+		// Recreate the backup name from the parsed value, without the extension
+		name, err := sp.opts.GenBackupName(when)
+		if err != nil {
+			return nil, err
+		}
+
+		bkups = append(bkups, &sftpBackup{
+			provider: sp,
+			path:     path.Join(sp.Directory, info.Name()),
+			name:     name,
+			when:     when,
+			size:     uint64(info.Size()),
+			reason:   backup.Unknown,
+		})
+	}
+	return bkups, nil
+}
+
+// cleanPartial removes an incomplete upload if it has been left for longer
+// than the configured time, the same as for local archives
+func (sp *SFTPProvider) cleanPartial(client *sftp.Client, info os.FileInfo) {
+	log := sp.logger().WithField("filename", info.Name())
+
+	age := time.Since(info.ModTime())
+	if sp.Tar.CleanPartial == 0 || age < sp.Tar.CleanPartial {
+		log.Debug("ignoring incomplete backup")
+		return
+	}
+
+	if sp.opts.DryRun {
+		log.Infof("would remove incomplete backup, last written %s ago", age.Round(time.Second))
+		return
+	}
+
+	log.Infof("removing incomplete backup, last written %s ago", age.Round(time.Second))
+	err := client.Remove(path.Join(sp.Directory, info.Name()))
+	if err != nil {
+		log.WithError(err).Warn("failed to remove incomplete backup")
+	}
+}
+
+func (sp *SFTPProvider) Restore(bkup backup.Backup, dest string) error {
+	sb, ok := bkup.(*sftpBackup)
+	if !ok {
+		return fmt.Errorf("backup %s is not stored over SFTP", bkup.Name())
+	}
+	return sp.archives().restore(sb.path, dest)
+}
+
+// Manifest downloads the manifest uploaded alongside a backup
+func (sp *SFTPProvider) Manifest(bkup backup.Backup) (*backup.Manifest, error) {
+	sb, ok := bkup.(*sftpBackup)
+	if !ok {
+		return nil, fmt.Errorf("backup %s is not stored over SFTP", bkup.Name())
+	}
+	return sp.archives().manifest(sb.path)
+}
+
+func (sp *SFTPProvider) Verify(bkup backup.Backup) error {
+	sb, ok := bkup.(*sftpBackup)
+	if !ok {
+		return fmt.Errorf("backup %s is not stored over SFTP", bkup.Name())
+	}
+	return sp.archives().verify(sb.name, sb.path)
+}
+
+// Open downloads the archive of a backup
+func (sp *SFTPProvider) Open(bkup backup.Backup) (io.ReadCloser, string, error) {
+	sb, ok := bkup.(*sftpBackup)
+	if !ok {
+		return nil, "", fmt.Errorf("backup %s is not stored over SFTP", bkup.Name())
+	}
+	in, err := sp.archives().open(sb.path)
+	if err != nil {
+		return nil, "", err
+	}
+	return in, path.Base(sb.path), nil
+}
+
+func (sp *SFTPProvider) Source() string {
+	return sp.Tar.SourceDirectory
+}
+
+// Check ensures the host can be reached and the remote directory exists
+func (sp *SFTPProvider) Check() error {
+	client, err := sp.connect()
+	if err != nil {
+		return fmt.Errorf("%s: %v", sp.Host, err)
+	}
+	info, err := client.Stat(sp.Directory)
+	if err != nil {
+		return fmt.Errorf("%s:%s: %v", sp.Host, sp.Directory, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s:%s is not a directory", sp.Host, sp.Directory)
+	}
+	return nil
+}
+
+// Capacity reports the space on the remote filesystem, if the
+// server supports the statvfs extension
+func (sp *SFTPProvider) Capacity() (used uint64, total uint64, err error) {
+	client, err := sp.connect()
+	if err != nil {
+		return
+	}
+	stat, err := client.StatVFS(sp.Directory)
+	if err != nil {
+		return
+	}
+	used = (stat.Blocks - stat.Bfree) * stat.Frsize
+	total = used + stat.Bavail*stat.Frsize
+	return
+}
+
+var _ Provider = &SFTPProvider{}
+var _ Opener = &SFTPProvider{}
+var _ Capacity = &SFTPProvider{}
+var _ Replica = &SFTPProvider{}
@@ -0,0 +1,206 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpServer is an SSH server that only serves the sftp subsystem,
+// operating on the local filesystem
+type sftpServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+}
+
+func newSFTPServer(t *testing.T, hostKey ssh.Signer, authorized ssh.PublicKey) *sftpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if meta.User() == "minecraft" && bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	server := &sftpServer{listener: listener, config: config}
+	go server.serve()
+	return server
+}
+
+func (server *sftpServer) serve() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		go server.handle(conn)
+	}
+}
+
+func (server *sftpServer) handle(conn net.Conn) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, server.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "")
+			continue
+		}
+		channel, requests, err := newChan.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				// The payload is the length-prefixed subsystem name
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					go func() {
+						server, err := sftp.NewServer(channel)
+						if err == nil {
+							server.Serve()
+						}
+						channel.Close()
+					}()
+				}
+			}
+		}()
+	}
+}
+
+func TestSFTPRoundTrip(t *testing.T) {
+	dir, src, opts := newTestSource(t, map[string]string{"world/level.dat": "level"})
+	remote := filepath.Join(dir, "remote")
+	err := os.Mkdir(remote, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys for the server, and for the client to log in with
+	_, hostPriv, _ := ed25519.GenerateKey(rand.Reader)
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPub, clientPriv, _ := ed25519.GenerateKey(rand.Reader)
+	authorized, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(clientPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "id_ed25519")
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newSFTPServer(t, hostKey, authorized)
+	defer server.listener.Close()
+	addr := server.listener.Addr().(*net.TCPAddr)
+
+	newProvider := func(hostKey ssh.PublicKey) (Provider, error) {
+		knownHosts := filepath.Join(dir, "known_hosts")
+		line := knownhosts.Line([]string{knownhosts.Normalize(addr.String())}, hostKey)
+		err := ioutil.WriteFile(knownHosts, []byte(line+"\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		prov, _, err := NewSFTP([]string{
+			"-s", src,
+			"--sftp-host", "127.0.0.1",
+			"--sftp-port", strconv.Itoa(addr.Port),
+			"--sftp-user", "minecraft",
+			"--sftp-key", keyFile,
+			"--sftp-known-hosts", knownHosts,
+			"--sftp-dir", remote,
+		}, opts)
+		return prov, err
+	}
+
+	// An unknown host key must be refused
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _ := ssh.NewPublicKey(otherPub)
+	_, err = newProvider(otherKey)
+	if err == nil {
+		t.Fatal("connected to a host with a mismatched key")
+	}
+
+	prov, err := newProvider(hostKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prov.(*SFTPProvider).Close()
+
+	when := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	bkup, err := prov.Create(context.Background(), "mcb-2021-01-01-00:00", when)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"mcb-2021-01-01-00:00.tar.gz", "mcb-2021-01-01-00:00.tar.gz.manifest.json"} {
+		if _, err := os.Stat(filepath.Join(remote, name)); err != nil {
+			t.Errorf("expected %s to be uploaded: %v", name, err)
+		}
+	}
+
+	backups, err := prov.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Name() != bkup.Name() || !backups[0].When().Equal(when) {
+		t.Fatalf("expected to list the backup, got %v", backups)
+	}
+
+	err = prov.Verify(backups[0])
+	if err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+
+	dest := filepath.Join(dir, "restored")
+	err = prov.Restore(backups[0], dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := ioutil.ReadFile(filepath.Join(dest, "world", "level.dat"))
+	if err != nil || string(restored) != "level" {
+		t.Errorf("restored file doesn't match the original: %q %v", restored, err)
+	}
+
+	err = backups[0].Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	left, _ := ioutil.ReadDir(remote)
+	if len(left) != 0 {
+		t.Errorf("files left after deleting the backup: %d", len(left))
+	}
+}
@@ -0,0 +1,75 @@
+package provider
+
+import (
+	"os"
+	"time"
+
+	"github.com/spritsail/mcbackup/backup"
+)
+
+type sftpBackup struct {
+	provider *SFTPProvider
+	path     string
+	size     uint64
+
+	name   string
+	when   time.Time
+	reason backup.Reason
+}
+
+func (sb *sftpBackup) Name() string {
+	return sb.name
+}
+
+func (sb *sftpBackup) When() time.Time {
+	return sb.when
+}
+
+func (sb *sftpBackup) Path() string {
+	return sb.provider.User + "@" + sb.provider.Host + ":" + sb.path
+}
+
+func (sb *sftpBackup) manifestPath() string {
+	return manifestName(sb.path)
+}
+
+// Delete removes the backup and its manifest from the remote directory
+func (sb *sftpBackup) Delete() error {
+	client, err := sb.provider.connect()
+	if err != nil {
+		return err
+	}
+	err = client.Remove(sb.path)
+	if err != nil {
+		return err
+	}
+	err = client.Remove(sb.manifestPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Size is the size of the remote file, as listed in the directory
+func (sb *sftpBackup) Size() (uint64, error) {
+	return sb.size, nil
+}
+
+func (sb *sftpBackup) SpaceUsed() (uint64, error) {
+	return sb.size, nil
+}
+
+func (sb *sftpBackup) Reason() backup.Reason {
+	return sb.reason
+}
+
+func (sb *sftpBackup) AddReason(r backup.Reason) {
+	sb.reason |= r
+}
+
+func (sb *sftpBackup) SetReason(r backup.Reason) {
+	sb.reason = r
+}
+
+var _ backup.Backup = &sftpBackup{}
+var _ backup.Pather = &sftpBackup{}
@@ -1,13 +1,16 @@
 package provider
 
 import (
+	"archive/tar"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"path"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/jessevdk/go-flags"
-	"github.com/mholt/archiver"
 	"github.com/sirupsen/logrus"
 	"github.com/spritsail/mcbackup/backup"
 	"github.com/spritsail/mcbackup/config"
@@ -19,18 +22,15 @@ func init() {
 	log = logrus.WithField("prefix", "tar")
 }
 
-type TarArchiver interface {
-	archiver.Archiver
-	fmt.Stringer
-}
-
 type TarProvider struct {
 	ArchiveProvider
 	opts      *config.Options
-	tar       TarArchiver
+	comp      *compressor
+	Progress  ProgressFunc
 	Algo      string `short:"c" long:"tar-compression" description:"compression algorithm used for the tar archive" env:"TAR_COMPRESSION" default:"gzip"`
 	Extension string `long:"tar-extension" description:"file extension used for backup archives"`
-	Level     int    `long:"compression-level" description:"level of the compression (algorithm dependent)" env:"COMPRESSION_LEVEL"`
+	Level     int    `long:"compression-level" description:"level of the compression (algorithm dependent), or 0 for the default" env:"COMPRESSION_LEVEL"`
+	Threads   int    `long:"compression-threads" description:"number of threads used for compression, or 0 for all CPUs (zstd only)" env:"COMPRESSION_THREADS"`
 }
 
 func NewTar(args []string, opts *config.Options) (p Provider, remain []string, err error) {
@@ -38,6 +38,10 @@ func NewTar(args []string, opts *config.Options) (p Provider, remain []string, e
 	tarOpts.opts = opts
 
 	parser := flags.NewParser(&tarOpts, flags.IgnoreUnknown)
+	err = opts.ApplyConfig(parser, "tar")
+	if err != nil {
+		return
+	}
 	remain, err = parser.ParseArgs(args)
 	if err != nil {
 		return
@@ -45,105 +49,342 @@ func NewTar(args []string, opts *config.Options) (p Provider, remain []string, e
 
 	// Attempt to initialise archive-global options
 	err = tarOpts.InitArchive()
+	if err != nil {
+		return
+	}
 
-	// TODO: Validate the CompressionLevel now instead of later
-	switch tarOpts.Algo {
-	case "gz":
-		fallthrough
-	case "gzip":
-		tarOpts.tar = &archiver.TarGz{
-			Tar:              archiver.DefaultTar,
-			CompressionLevel: tarOpts.Level,
-		}
-	case "bz2":
-		fallthrough
-	case "bzip2":
-		tarOpts.tar = &archiver.TarBz2{
-			Tar:              archiver.DefaultTar,
-			CompressionLevel: tarOpts.Level,
-		}
-	case "lz4":
-		tarOpts.tar = &archiver.TarLz4{
-			Tar:              archiver.DefaultTar,
-			CompressionLevel: tarOpts.Level,
-		}
-	case "xz":
-		tarOpts.tar = archiver.DefaultTarXz
-	default:
-		err = fmt.Errorf("unknown compression algorithm '%s'", tarOpts.Algo)
+	err = tarOpts.initTar()
+	if err != nil {
+		return
+	}
+
+	p = &tarOpts
+	return
+}
+
+// initTar validates the compression options, once they are parsed
+func (tp *TarProvider) initTar() (err error) {
+	tp.comp, err = findCompressor(tp.Algo)
+	if err != nil {
 		return
 	}
 
+	if tp.comp.maxLevel == 0 && tp.Level != 0 {
+		log.Warnf("compression level is not supported by %s and will be ignored", tp.Algo)
+	} else if err = tp.comp.checkLevel(tp.Level); err != nil {
+		return fmt.Errorf("invalid compression level %d for %s, %v", tp.Level, tp.Algo, err)
+	}
+
 	// Default and sanitise file extension
-	if tarOpts.Extension == "" {
-		tarOpts.Extension = tarOpts.tar.String()
-		log.WithField("extension", tarOpts.Extension).
+	if tp.Extension == "" {
+		tp.Extension = tp.comp.extensions[0]
+		log.WithField("extension", tp.Extension).
 			Debugf("Using default file extension")
+	} else {
+		tp.Extension = sanitiseExtension(tp.Extension)
 	}
 
 	// Validate the file extension against the compression algo
-	err = tarOpts.tar.CheckExt("testfilename." + tarOpts.Extension)
+	err = tp.comp.checkExt(tp.Extension)
 	if err != nil {
 		return
 	}
 
-	p = &tarOpts
+	tp.Progress = LogProgress(log, 30*time.Second)
 	return
 }
 
-func (tp *TarProvider) Create(name string, when time.Time) (backup.Backup, error) {
-	filename := name + "." + tp.Extension
-	filepath := path.Join(tp.BackupDirectory, filename)
-	log.WithField("filename", filename).Debugf("creating tar backup")
+// sanitiseExtension normalises a user-provided file extension so that it
+// always includes the tar part, without a leading dot
+func sanitiseExtension(ext string) string {
+	ext = strings.TrimLeft(ext, ".")
+
+	// Short-form extensions such as tgz or tzst already imply tar
+	if ext == "tar" || strings.HasPrefix(ext, "tar.") ||
+		(strings.HasPrefix(ext, "t") && !strings.Contains(ext, ".")) {
+		return ext
+	}
+	return "tar." + ext
+}
+
+func (tp *TarProvider) Create(ctx context.Context, name string, when time.Time) (backup.Backup, error) {
+	log.WithField("filename", name+"."+tp.fileExt()).Debugf("creating tar backup")
+
+	manifest := backup.NewManifest(name, when)
+	return tp.storeArchive(name, when, tp.fileExt(), func(out io.Writer) error {
+		return tp.writeTar(ctx, out, manifest)
+	}, manifest)
+}
+
+// Replicate stores a copy of a backup from another provider
+func (tp *TarProvider) Replicate(ctx context.Context, src Provider, bkup backup.Backup) (backup.Backup, error) {
+	return replicate(ctx, src, bkup, tp.fileExt(), tp.archiveDir,
+		func(write func(out io.Writer) error, m *backup.Manifest) (backup.Backup, error) {
+			return tp.storeArchive(bkup.Name(), bkup.When(), tp.fileExt(), write, m)
+		})
+}
+
+// fileExt is the extension of new archives, including that marking
+// them as encrypted
+func (tp *TarProvider) fileExt() string {
+	return tp.archiveExt(tp.Extension)
+}
+
+// archiveDir writes a tar archive of a directory other than the source,
+// with the same options
+func (tp *TarProvider) archiveDir(ctx context.Context, out io.Writer, dir, root string, m *backup.Manifest) error {
+	other := *tp
+	other.SourceDirectory = dir
+	other.root = root
+	return other.writeTar(ctx, out, m)
+}
+
+// writeTar streams a compressed tar archive of the source directory to out,
+// encrypted if configured, recording each file written in the manifest
+func (tp *TarProvider) writeTar(ctx context.Context, out io.Writer, m *backup.Manifest) (err error) {
+	ew, err := tp.encrypt(out)
+	if err != nil {
+		return fmt.Errorf("encrypting: %v", err)
+	}
+	cw, err := tp.comp.writer(ew, tp.Level, tp.Threads)
+	if err != nil {
+		return fmt.Errorf("creating compressor: %v", err)
+	}
+	tw := tar.NewWriter(cw)
+
+	var progress Progress
+	err = tp.walkSource(func(fpath, name string, info os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := writeTarEntry(ctx, tw, m, fpath, name, info)
+		if err != nil {
+			return err
+		}
+
+		progress.Files++
+		progress.Bytes += uint64(n)
+		if tp.Progress != nil {
+			tp.Progress(progress)
+		}
+		return nil
+	})
+
+	// Always close the writers to release any resources they hold
+	if e := tw.Close(); err == nil {
+		err = e
+	}
+	if e := cw.Close(); err == nil {
+		err = e
+	}
+	if e := ew.Close(); err == nil {
+		err = e
+	}
+	return
+}
+
+// writeTarEntry writes a single file to a tar archive and the manifest,
+// returning the number of bytes of file content written
+func writeTarEntry(ctx context.Context, tw *tar.Writer, m *backup.Manifest, fpath, name string, info os.FileInfo) (int64, error) {
+	mode := info.Mode()
+	if !mode.IsRegular() && !mode.IsDir() && mode&os.ModeSymlink == 0 {
+		// Sockets, devices and pipes have no place in a backup
+		log.WithField("file", fpath).
+			Debug("skipping irregular file")
+		return 0, nil
+	}
+
+	var link string
+	if mode&os.ModeSymlink != 0 {
+		var err error
+		link, err = os.Readlink(fpath)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return 0, fmt.Errorf("%s: making header: %v", fpath, err)
+	}
+	hdr.Name = name
+	if mode.IsDir() {
+		hdr.Name += "/"
+	}
+
+	err = tw.WriteHeader(hdr)
+	if err != nil {
+		return 0, fmt.Errorf("%s: writing header: %v", fpath, err)
+	}
+
+	rel := stripTopLevel(name)
+	if !mode.IsRegular() {
+		if rel != "" {
+			_, err = m.Add(rel, info, link, nil)
+		}
+		return 0, err
+	}
 
-	// Create the backup
-	err := tp.tar.Archive([]string{tp.SourceDirectory}, filepath)
+	file, err := os.Open(fpath)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
+	defer file.Close()
 
-	bkup := &ArchiveBackup{
-		path:   filepath,
-		name:   name,
-		when:   when,
-		reason: backup.Unknown,
+	// The manifest reads the file through to the archive whilst hashing it.
+	// The header has already been written, so a file that shrinks whilst
+	// being read is padded with zeros rather than failing the whole backup.
+	cr := &countReader{r: ctxReader{ctx, file}}
+	r := io.TeeReader(io.LimitReader(io.MultiReader(cr, zeroReader{}), hdr.Size), tw)
+	n, err := m.Add(rel, info, link, r)
+	if err != nil {
+		return n, fmt.Errorf("%s: copying contents: %v", fpath, err)
+	}
+	if cr.n < hdr.Size {
+		log.WithField("file", fpath).
+			Warnf("file shrank from %d to %d bytes whilst being read, padding it with zeros", hdr.Size, cr.n)
 	}
+	return n, nil
+}
+
+// countReader counts the bytes read through it
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
 
-	return bkup, err
+// zeroReader reads an endless stream of zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
 }
 
 func (tp *TarProvider) List() (backup.Backups, error) {
-	infos, err := ioutil.ReadDir(tp.ArchiveProvider.BackupDirectory)
+	return tp.listArchives(tp.opts, tp.Extension)
+}
+
+func (tp *TarProvider) Restore(bkup backup.Backup, dest string) (err error) {
+	ab, ok := bkup.(*ArchiveBackup)
+	if !ok {
+		return fmt.Errorf("backup %s is not a tar archive", bkup.Name())
+	}
+
+	if dest == "" {
+		_, err = moveAside(tp.SourceDirectory, tp.BackupDirectory)
+		if err != nil {
+			return
+		}
+		dest = tp.SourceDirectory
+	}
+	log.WithField("filename", ab.path).
+		WithField("dest", dest).
+		Debugf("restoring tar backup")
+
+	ex := newExtractor(dest)
+	if err := tp.readTar(ab.path, ex.extract); err != nil {
+		return err
+	}
+	return ex.finish()
+}
+
+func (tp *TarProvider) Verify(bkup backup.Backup) error {
+	ab, ok := bkup.(*ArchiveBackup)
+	if !ok {
+		return fmt.Errorf("backup %s is not a tar archive", bkup.Name())
+	}
+
+	return tp.verifyArchive(ab, func(fn entryFunc) error {
+		return tp.readTar(ab.path, fn)
+	})
+}
+
+// readTar calls fn for every file in a compressed tar archive
+func (tp *TarProvider) readTar(filepath string, fn entryFunc) error {
+	in, err := os.Open(filepath)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer in.Close()
+	return tp.readTarStream(filepath, in, fn)
+}
 
-	var bkups backup.Backups
-	for _, info := range infos {
-		if !tp.opts.IsMcbackup(info.Name()) {
-			continue
+// readTarStream calls fn for every file in a compressed tar stream, which is
+// decrypted if the name it is stored under marks it as encrypted
+func (tp *TarProvider) readTarStream(name string, in io.Reader, fn entryFunc) error {
+	// OpenPGP archives are only authenticated at the end, so they are
+	// decrypted in full before anything is extracted from them. age checks
+	// each chunk before decrypting it, so its archives can be streamed.
+	if strings.HasSuffix(name, "."+pgpExtension) {
+		decrypted, err := tp.decryptToFile(name, in)
+		if err != nil {
+			return err
 		}
+		defer os.Remove(decrypted)
 
-		when, err := tp.opts.ParseBackupName(info.Name())
+		file, err := os.Open(decrypted)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		defer file.Close()
+		return tp.readTarStream(strings.TrimSuffix(name, "."+pgpExtension), file, fn)
+	}
+
+	in, err := tp.decrypt(name, in)
+	if err != nil {
+		return err
+	}
+	cr, err := tp.comp.reader(in)
+	if err != nil {
+		return err
+	}
+	defer cr.Close()
+
+	tr := tar.NewReader(cr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
 		}
-		// Recreate the backup name from the parsed value
-		// This gets around the issue of trying to remove the file extension
-		backupName, err := tp.opts.GenBackupName(when)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		archiveBackup := &ArchiveBackup{
-			path:   path.Join(tp.ArchiveProvider.BackupDirectory, info.Name()),
-			name:   backupName,
-			when:   when,
-			reason: backup.Unknown,
+
+		// Hard links are named relative to the archive root, same as the entry
+		linkname := hdr.Linkname
+		if hdr.Typeflag == tar.TypeLink {
+			linkname = stripTopLevel(linkname)
+		}
+
+		err = fn(stripTopLevel(hdr.Name), hdr.FileInfo(), linkname, tr)
+		if err != nil {
+			return err
 		}
-		bkups = append(bkups, archiveBackup)
 	}
 
-	return bkups, nil
+	if isEncrypted(name) {
+		// Read to the end, so that the whole archive is authenticated
+		_, err = io.Copy(ioutil.Discard, in)
+		return err
+	}
+	return nil
+}
+
+func (tp *TarProvider) Source() string {
+	return tp.SourceDirectory
 }
 
 var _ Provider = &TarProvider{}
+var _ Opener = &TarProvider{}
+var _ Capacity = &TarProvider{}
+var _ Replica = &TarProvider{}
@@ -1,6 +1,18 @@
 package provider
 
-import "testing"
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spritsail/mcbackup/backup"
+	"github.com/spritsail/mcbackup/config"
+)
 
 func TestSanitiseExtension(t *testing.T) {
 	var inputs = []string{"gz", ".gz", "tar.gz", ".tar.gz"}
@@ -13,3 +25,145 @@ func TestSanitiseExtension(t *testing.T) {
 		}
 	}
 }
+
+func TestSanitiseExtensionShortForm(t *testing.T) {
+	var tests = map[string]string{
+		"zst":      "tar.zst",
+		".tar.zst": "tar.zst",
+		"tzst":     "tzst",
+		".tgz":     "tgz",
+		"tar":      "tar",
+	}
+	for ext, expected := range tests {
+		out := sanitiseExtension(ext)
+		if out != expected {
+			t.Errorf("sanitiseExtension(\"%s\") -> %s, should be %s",
+				ext, out, expected)
+		}
+	}
+}
+
+func TestTarRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcbackup-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "server")
+	err = os.MkdirAll(filepath.Join(src, "world", "region"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(src, "world", "region", "r.0.0.mca"), []byte("region data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink("world/region", filepath.Join(src, "regions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &config.Options{BackupPrefix: "mcb-", BackupFormat: "%F-%H:%M"}
+	for algo := range compressors {
+		backupDir := filepath.Join(dir, "backups-"+algo)
+		prov, _, err := NewTar([]string{"-c", algo, "-s", src, "-b", backupDir}, opts)
+		if err != nil {
+			t.Fatalf("NewTar(%s): %v", algo, err)
+		}
+
+		bkup, err := prov.Create(context.Background(), "mcb-2021-01-01-00:00", time.Now())
+		if err != nil {
+			t.Fatalf("Create(%s): %v", algo, err)
+		}
+
+		bkups, err := prov.List()
+		if err != nil || len(bkups) != 1 || bkups[0].Name() != bkup.Name() {
+			t.Errorf("List(%s) -> %v, %v, should be [%s]", algo, bkups, err, bkup.Name())
+		}
+
+		err = prov.Verify(bkup)
+		if err != nil {
+			t.Errorf("Verify(%s): %v", algo, err)
+		}
+
+		dest := filepath.Join(dir, "restore-"+algo)
+		err = prov.Restore(bkup, dest)
+		if err != nil {
+			t.Fatalf("Restore(%s): %v", algo, err)
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dest, "regions", "r.0.0.mca"))
+		if err != nil || string(data) != "region data" {
+			t.Errorf("restored %s backup contains %q, %v", algo, data, err)
+		}
+	}
+}
+
+func TestTarEntryShrinks(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "level.dat")
+	err := ioutil.WriteFile(fpath, []byte("level data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The file is truncated after it was found, but before it is read
+	err = os.Truncate(fpath, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	m := backup.NewManifest("mcb-2021-01-01-00:00", time.Now())
+	n, err := writeTarEntry(context.Background(), tw, m, fpath, "server/level.dat", info)
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != info.Size() {
+		t.Errorf("expected %d bytes to be written, got %d", info.Size(), n)
+	}
+
+	tr := tar.NewReader(&buf)
+	_, err = tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(tr)
+	if err != nil || string(data) != "level\x00\x00\x00\x00\x00" {
+		t.Errorf("expected the entry to be padded with zeros, got %q, %v", data, err)
+	}
+}
+
+func TestCompressionLevel(t *testing.T) {
+	var tests = []struct {
+		algo  string
+		level int
+		valid bool
+	}{
+		{"gzip", 0, true},
+		{"gzip", 9, true},
+		{"gzip", 12, false},
+		{"gz", -1, false},
+		{"bzip2", 10, false},
+		{"lz4", 16, true},
+		{"zstd", 22, true},
+		{"zstd", 23, false},
+		{"xz", 6, true},
+	}
+	for _, test := range tests {
+		tp := &TarProvider{Algo: test.algo, Level: test.level}
+		err := tp.initTar()
+		if test.valid && err != nil {
+			t.Errorf("%s level %d rejected: %v", test.algo, test.level, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("%s level %d accepted, should be invalid", test.algo, test.level)
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package provider
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -14,9 +19,17 @@ import (
 )
 
 type ZfsProvider struct {
-	opts      *config.Options
-	Dataset   string `long:"zfs-dataset" description:"Dataset/volume name" env:"ZFS_DATASET" required:"true"`
-	Recursive bool   `long:"zfs-recursive" description:"Should snapshots be recursive" env:"ZFS_SNAPSHOT_RECURSE"`
+	opts       *config.Options
+	mountpoint string
+	Dataset    string `long:"zfs-dataset" description:"Dataset/volume name" env:"ZFS_DATASET" required:"true"`
+	Recursive  bool   `long:"zfs-recursive" description:"Should snapshots be recursive" env:"ZFS_SNAPSHOT_RECURSE"`
+
+	ManifestDir string `long:"zfs-manifest-dir" description:"Directory to save snapshot manifests to, needed to verify snapshots" env:"ZFS_MANIFEST_DIRECTORY"`
+
+	SendDir       string `long:"zfs-send-dir" description:"Directory to write a zfs send stream of each snapshot to, which can be imported elsewhere" env:"ZFS_SEND_DIRECTORY"`
+	SendCommand   string `long:"zfs-send-command" description:"Command to pipe a zfs send stream of each snapshot into, run by the shell, e.g. 'ssh backup zfs receive -F tank/minecraft'" env:"ZFS_SEND_COMMAND"`
+	SendMode      string `long:"zfs-send-mode" description:"Send streams incrementally from the last snapshot sent, or send every snapshot in full" env:"ZFS_SEND_MODE" choice:"incremental" choice:"full" default:"incremental"`
+	SendFullEvery uint   `long:"zfs-send-full-every" description:"Send a full stream after this many incremental streams, or 0 to only send one when there is no snapshot to send from" env:"ZFS_SEND_FULL_EVERY"`
 }
 
 func NewZFS(args []string, opts *config.Options) (p Provider, remain []string, err error) {
@@ -24,6 +37,10 @@ func NewZFS(args []string, opts *config.Options) (p Provider, remain []string, e
 	zfsOpts.opts = opts
 
 	parser := flags.NewParser(&zfsOpts, flags.IgnoreUnknown)
+	err = opts.ApplyConfig(parser, "zfs")
+	if err != nil {
+		return
+	}
 	remain, err = parser.ParseArgs(args)
 	if err != nil {
 		return
@@ -34,12 +51,26 @@ func NewZFS(args []string, opts *config.Options) (p Provider, remain []string, e
 	if err != nil {
 		return
 	}
+	zfsOpts.mountpoint = d.Properties[zfs.DatasetPropMountpoint].Value
+
+	if zfsOpts.ManifestDir != "" {
+		err = checkDirectory(zfsOpts.ManifestDir, "manifest")
+		if err != nil {
+			return
+		}
+	}
+	if zfsOpts.SendDir != "" {
+		err = checkDirectory(zfsOpts.SendDir, "send")
+		if err != nil {
+			return
+		}
+	}
 
 	p = &zfsOpts
 	return
 }
 
-func (zp *ZfsProvider) Create(name string, when time.Time) (backup.Backup, error) {
+func (zp *ZfsProvider) Create(ctx context.Context, name string, when time.Time) (backup.Backup, error) {
 	log := logrus.WithField("prefix", "zfs")
 
 	log.Info("taking zfs snapshot")
@@ -60,15 +91,53 @@ func (zp *ZfsProvider) Create(name string, when time.Time) (backup.Backup, error
 		humanize.Bytes(refSize))
 
 	bkup := &zfsSnapshot{
-		dataset: snapName,
-		name:    name,
-		when:    when,
-		reason:  backup.Unknown,
+		provider: zp,
+		dataset:  snapName,
+		name:     name,
+		when:     when,
+		reason:   backup.Unknown,
+		manifest: zp.manifestPath(name),
+	}
+
+	if bkup.manifest != "" {
+		err = zp.saveManifest(bkup)
+		if err != nil {
+			// The snapshot itself is still usable without a manifest
+			log.WithError(err).Warn("failed to write snapshot manifest")
+		}
 	}
 
 	return bkup, nil
 }
 
+// saveManifest records the contents of a new snapshot. Snapshots are
+// read-only, so reading it back gives exactly what was backed up.
+func (zp *ZfsProvider) saveManifest(snap *zfsSnapshot) error {
+	m := backup.NewManifest(snap.name, snap.when)
+	err := zp.readSnapshot(snap, func(rel string, info os.FileInfo, linkname string, r io.Reader) error {
+		if rel == "" {
+			return nil
+		}
+		_, err := m.Add(rel, info, linkname, r)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	m.Finish()
+
+	return writeManifest(snap.manifest, m.Write)
+}
+
+// manifestPath is where the manifest for the named snapshot is stored,
+// or empty if manifests are not enabled
+func (zp *ZfsProvider) manifestPath(name string) string {
+	if zp.ManifestDir == "" {
+		return ""
+	}
+	return filepath.Join(zp.ManifestDir, name+backup.ManifestExtension)
+}
+
 func (zp *ZfsProvider) List() (bs backup.Backups, err error) {
 	ds, err := zfs.DatasetOpen(zp.Dataset)
 	defer ds.Close()
@@ -101,14 +170,150 @@ func (zp *ZfsProvider) List() (bs backup.Backups, err error) {
 			}
 
 			bs = append(bs, &zfsSnapshot{
-				dataset: name,
-				name:    snapName,
-				when:    when,
-				reason:  backup.Unknown,
+				provider: zp,
+				dataset:  name,
+				name:     snapName,
+				when:     when,
+				reason:   backup.Unknown,
+				manifest: zp.manifestPath(snapName),
 			})
 		}
 	}
 	return bs, nil
 }
 
+// Restore copies the contents of a snapshot out of the hidden
+// .zfs/snapshot directory at the root of the dataset
+func (zp *ZfsProvider) Restore(bkup backup.Backup, dest string) (err error) {
+	log := logrus.WithField("prefix", "zfs")
+
+	snap, ok := bkup.(*zfsSnapshot)
+	if !ok {
+		return fmt.Errorf("backup %s is not a zfs snapshot", bkup.Name())
+	}
+
+	if dest == "" {
+		_, err = moveAside(zp.mountpoint)
+		if err != nil {
+			return
+		}
+		dest = zp.mountpoint
+	}
+	log.WithField("snapshot", snap.dataset).
+		WithField("dest", dest).
+		Debugf("restoring zfs snapshot")
+
+	ex := newExtractor(dest)
+	if err := zp.readSnapshot(snap, ex.extract); err != nil {
+		return err
+	}
+	return ex.finish()
+}
+
+func (zp *ZfsProvider) Manifest(bkup backup.Backup) (*backup.Manifest, error) {
+	snap, ok := bkup.(*zfsSnapshot)
+	if !ok {
+		return nil, fmt.Errorf("backup %s is not a zfs snapshot", bkup.Name())
+	}
+	if snap.manifest == "" {
+		return nil, fmt.Errorf("no manifest directory configured for zfs snapshots")
+	}
+	return readManifest(snap.manifest, backup.ReadManifest)
+}
+
+// Verify reads every file in the snapshot back and compares it to the
+// manifest written when the snapshot was taken
+func (zp *ZfsProvider) Verify(bkup backup.Backup) error {
+	m, err := zp.Manifest(bkup)
+	if err != nil {
+		return err
+	}
+
+	snap := bkup.(*zfsSnapshot)
+	return verifyContents(m, func(fn entryFunc) error {
+		return zp.readSnapshot(snap, fn)
+	})
+}
+
+// readSnapshot calls fn for every file in a snapshot
+func (zp *ZfsProvider) readSnapshot(snap *zfsSnapshot, fn entryFunc) error {
+	snapDir, err := zp.snapshotDir(snap)
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(snapDir, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(snapDir, fpath)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			rel = ""
+		}
+
+		var linkname string
+		if info.Mode()&os.ModeSymlink != 0 {
+			linkname, err = os.Readlink(fpath)
+			if err != nil {
+				return err
+			}
+		}
+
+		var file *os.File
+		if info.Mode().IsRegular() {
+			file, err = os.Open(fpath)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+		}
+
+		return fn(filepath.ToSlash(rel), info, linkname, file)
+	})
+}
+
+// snapshotDir finds the directory that the snapshot contents can be read from
+func (zp *ZfsProvider) snapshotDir(snap *zfsSnapshot) (string, error) {
+	if zp.mountpoint == "" || !filepath.IsAbs(zp.mountpoint) {
+		return "", fmt.Errorf("dataset %s is not mounted (mountpoint '%s')",
+			zp.Dataset, zp.mountpoint)
+	}
+	return filepath.Join(zp.mountpoint, ".zfs", "snapshot", snap.name), nil
+}
+
+func (zp *ZfsProvider) Source() string {
+	return zp.mountpoint
+}
+
+// Check ensures the dataset can still be opened
+func (zp *ZfsProvider) Check() error {
+	ds, err := zfs.DatasetOpen(zp.Dataset)
+	defer ds.Close()
+	return err
+}
+
+// Capacity reports the space used by the dataset, out of the space it could use
+func (zp *ZfsProvider) Capacity() (used uint64, total uint64, err error) {
+	ds, err := zfs.DatasetOpen(zp.Dataset)
+	defer ds.Close()
+	if err != nil {
+		return
+	}
+
+	used, err = strconv.ParseUint(ds.Properties[zfs.DatasetPropUsed].Value, 10, 64)
+	if err != nil {
+		return
+	}
+	avail, err := strconv.ParseUint(ds.Properties[zfs.DatasetPropAvailable].Value, 10, 64)
+	if err != nil {
+		return
+	}
+	total = used + avail
+	return
+}
+
 var _ Provider = &ZfsProvider{}
+var _ Capacity = &ZfsProvider{}
@@ -1,6 +1,7 @@
 package provider
 
 import (
+	"os"
 	"strconv"
 	"time"
 
@@ -9,7 +10,11 @@ import (
 )
 
 type zfsSnapshot struct {
-	dataset string
+	provider *ZfsProvider
+	dataset  string
+
+	// Path to the manifest, if manifests are enabled
+	manifest string
 
 	name   string
 	when   time.Time
@@ -24,13 +29,33 @@ func (zs *zfsSnapshot) When() time.Time {
 	return zs.when
 }
 
+func (zs *zfsSnapshot) Path() string {
+	return zs.dataset
+}
+
 func (zs *zfsSnapshot) Delete() error {
 	ds, err := zfs.DatasetOpen(zs.dataset)
 	defer ds.Close()
 	if err != nil {
 		return err
 	}
-	return ds.Destroy(true)
+	err = ds.Destroy(true)
+	if err != nil {
+		return err
+	}
+
+	if zs.manifest != "" {
+		err = os.Remove(zs.manifest)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// Streams are kept only whilst a snapshot still held needs them
+	if zs.provider != nil && zs.provider.SendDir != "" {
+		return zs.provider.pruneStreams(zs.name)
+	}
+	return nil
 }
 
 func (zs *zfsSnapshot) Size() (uint64, error) {
@@ -66,3 +91,4 @@ func (zs *zfsSnapshot) SetReason(r backup.Reason) {
 }
 
 var _ backup.Backup = &zfsSnapshot{}
+var _ backup.Pather = &zfsSnapshot{}
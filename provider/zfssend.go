@@ -0,0 +1,491 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	zfs "github.com/bicomsystems/go-libzfs"
+	"github.com/dustin/go-humanize"
+	"github.com/sirupsen/logrus"
+	"github.com/spritsail/mcbackup/backup"
+)
+
+const (
+	// sentProperty is the user property set on snapshots once they have been
+	// sent, holding the number of incremental streams since the last full one
+	sentProperty = "mcbackup:sent"
+
+	// Streams are named <snapshot>.full.zfs, or <snapshot>.incr.<base>.zfs
+	// when sent incrementally from the base snapshot
+	streamExtension   = ".zfs"
+	streamFull        = "full"
+	streamIncremental = "incr"
+)
+
+// Importer is implemented by providers that can load backups they exported
+// back in, for example onto another machine
+type Importer interface {
+	// Import loads the named backup, or the latest if name is empty,
+	// into target, along with any earlier backups it depends upon
+	Import(ctx context.Context, name, target string, force bool) error
+}
+
+// Exporter is implemented by providers that copy new backups elsewhere once
+// they are taken. Exporting may take a long time, so it is only started
+// once the server is saving again.
+type Exporter interface {
+	// Export copies a backup taken by Create
+	Export(ctx context.Context, bkup backup.Backup) error
+}
+
+// zfsStream is a zfs send stream of a snapshot, written to the send directory
+type zfsStream struct {
+	name string
+	when time.Time
+	path string
+
+	// Snapshot the stream was sent incrementally from, or empty for a full stream
+	base string
+}
+
+func (zs zfsStream) full() bool {
+	return zs.base == ""
+}
+
+// sentSnapshot is a snapshot that has been sent, either as a full stream
+// or incrementally after depth other streams
+type sentSnapshot struct {
+	name  string
+	when  time.Time
+	depth int
+}
+
+// sending reports whether snapshots are exported as send streams
+func (zp *ZfsProvider) sending() bool {
+	return zp.SendDir != "" || zp.SendCommand != ""
+}
+
+// Export sends a new snapshot, if send streams are configured. If sending
+// fails, the next snapshot is sent from the last one that was sent
+// successfully, so nothing is missing from the streams.
+func (zp *ZfsProvider) Export(ctx context.Context, bkup backup.Backup) error {
+	snap, ok := bkup.(*zfsSnapshot)
+	if !ok {
+		return fmt.Errorf("backup %s is not a zfs snapshot", bkup.Name())
+	}
+	if !zp.sending() {
+		return nil
+	}
+	return zp.send(ctx, snap)
+}
+
+// send exports a new snapshot as a zfs send stream, written to the send
+// directory and piped to the send command. The stream is incremental from
+// the last snapshot that was sent, unless a full stream is due.
+func (zp *ZfsProvider) send(ctx context.Context, snap *zfsSnapshot) error {
+	log := logrus.WithField("prefix", "zfs").
+		WithField("snapshot", snap.dataset)
+
+	sent, err := zp.sentSnapshots()
+	if err != nil {
+		return err
+	}
+	base, depth := incrementalBase(sent, snap.when)
+	if zp.SendMode == streamFull || (zp.SendFullEvery > 0 && depth > int(zp.SendFullEvery)) {
+		base, depth = "", 0
+	}
+
+	kind := streamFull
+	if base != "" {
+		kind = streamIncremental
+		log = log.WithField("base", base)
+	}
+
+	ds, err := zfs.DatasetOpen(snap.dataset)
+	defer ds.Close()
+	if err != nil {
+		return err
+	}
+
+	var sinks []io.Writer
+	var file *os.File
+	var partial string
+	if zp.SendDir != "" {
+		filename := snap.name + "." + kind + streamExtension
+		if base != "" {
+			filename = snap.name + "." + kind + "." + base + streamExtension
+		}
+		partial = filepath.Join(zp.SendDir, filename+".partial")
+		file, err = os.Create(partial)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		defer os.Remove(partial)
+		sinks = append(sinks, file)
+	}
+
+	var cmd *exec.Cmd
+	var output bytes.Buffer
+	var stdin io.WriteCloser
+	if zp.SendCommand != "" {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", zp.SendCommand)
+		cmd.Env = append(os.Environ(),
+			"MCBACKUP_SNAPSHOT="+snap.dataset,
+			"MCBACKUP_BACKUP_NAME="+snap.name,
+			"MCBACKUP_STREAM="+kind,
+			"MCBACKUP_BASE="+base,
+		)
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+		stdin, err = cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+		err = cmd.Start()
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, stdin)
+	}
+
+	log.Infof("sending %s stream", kind)
+	start := time.Now()
+
+	// libzfs writes the stream to a file descriptor, so it is sent through a
+	// pipe to be copied to each destination
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	sendErr := make(chan error, 1)
+	go func() {
+		var err error
+		if base == "" {
+			err = ds.Send(w, zfs.SendFlags{})
+		} else {
+			err = ds.SendFrom(zp.Dataset+"@"+base, w, zfs.SendFlags{})
+		}
+		w.Close()
+		sendErr <- err
+	}()
+
+	size, err := io.Copy(io.MultiWriter(sinks...), ctxReader{ctx, r})
+	// Closing the reader stops the send if copying failed part way through
+	r.Close()
+	if serr := <-sendErr; err == nil && serr != nil {
+		err = fmt.Errorf("zfs send: %v", serr)
+	}
+
+	if cmd != nil {
+		stdin.Close()
+		cerr := cmd.Wait()
+		for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+			if line != "" {
+				log.Info(line)
+			}
+		}
+		if err == nil && cerr != nil {
+			err = fmt.Errorf("send command failed: %v", cerr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if file != nil {
+		err = file.Sync()
+		if err == nil {
+			err = os.Rename(partial, strings.TrimSuffix(partial, ".partial"))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Infof("sent %s %s stream in %s", humanize.Bytes(uint64(size)), kind,
+		time.Since(start).Round(time.Millisecond))
+
+	// Later snapshots can only be sent incrementally from one that was sent
+	return ds.SetUserProperty(sentProperty, strconv.Itoa(depth))
+}
+
+// sentSnapshots finds the mcbackup snapshots of the dataset that have been sent
+func (zp *ZfsProvider) sentSnapshots() (sent []sentSnapshot, err error) {
+	ds, err := zfs.DatasetOpen(zp.Dataset)
+	defer ds.Close()
+	if err != nil {
+		return
+	}
+
+	for _, child := range ds.Children {
+		if child.Type != zfs.DatasetTypeSnapshot {
+			continue
+		}
+		name := child.Properties[zfs.DatasetPropName].Value
+		snapName := name[strings.Index(name, "@")+1:]
+		if !zp.opts.IsMcbackup(snapName) {
+			continue
+		}
+		when, err := zp.opts.ParseBackupName(snapName)
+		if err != nil {
+			continue
+		}
+
+		// Unset user properties read as "-"
+		prop, err := child.GetUserProperty(sentProperty)
+		if err != nil {
+			return nil, err
+		}
+		depth, err := strconv.Atoi(prop.Value)
+		if err != nil {
+			continue
+		}
+		sent = append(sent, sentSnapshot{name: snapName, when: when, depth: depth})
+	}
+	return sent, nil
+}
+
+// incrementalBase picks the latest snapshot sent before a new one, to send
+// it incrementally from. The depth of the new stream is returned along with
+// it, which is 0 for a full stream if there is nothing to send from.
+func incrementalBase(sent []sentSnapshot, before time.Time) (base string, depth int) {
+	var latest *sentSnapshot
+	for i, s := range sent {
+		if s.when.Before(before) && (latest == nil || s.when.After(latest.when)) {
+			latest = &sent[i]
+		}
+	}
+	if latest == nil {
+		return "", 0
+	}
+	return latest.name, latest.depth + 1
+}
+
+// Import receives the streams written to the send directory into the target
+// dataset, starting from the last full stream before the named snapshot.
+// Snapshots that the target already holds are skipped, so imports can be
+// repeated to bring a copy up to date.
+func (zp *ZfsProvider) Import(ctx context.Context, name, target string, force bool) error {
+	log := logrus.WithField("prefix", "zfs").
+		WithField("target", target)
+
+	if zp.SendDir == "" {
+		return errors.New("no zfs send directory configured to import from")
+	}
+
+	streams, err := zp.readStreams()
+	if err != nil {
+		return err
+	}
+	chain, err := streamChain(streams, name)
+	if err != nil {
+		return err
+	}
+
+	for _, stream := range chain {
+		snap, err := zfs.DatasetOpen(target + "@" + stream.name)
+		snap.Close()
+		if err == nil {
+			log.Debugf("target already has snapshot %s", stream.name)
+			continue
+		}
+
+		log.Infof("receiving %s", filepath.Base(stream.path))
+		start := time.Now()
+		err = receiveStream(ctx, stream.path, target, force)
+		if err != nil {
+			return err
+		}
+		log.Infof("received snapshot %s in %s", stream.name,
+			time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// receiveStream runs zfs receive on a stream file. libzfs can only receive
+// into a dataset that already exists, which a full stream creates.
+func receiveStream(ctx context.Context, path, target string, force bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	args := []string{"receive"}
+	if force {
+		args = append(args, "-F")
+	}
+	cmd := exec.CommandContext(ctx, "zfs", append(args, target)...)
+	cmd.Stdin = file
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("zfs receive %s: %v: %s", filepath.Base(path), err,
+			strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// readStreams lists the stream files in the send directory
+func (zp *ZfsProvider) readStreams() (streams []zfsStream, err error) {
+	files, err := ioutil.ReadDir(zp.SendDir)
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		stem := strings.TrimSuffix(file.Name(), streamExtension)
+		if file.IsDir() || stem == file.Name() {
+			continue
+		}
+
+		var name, base string
+		if idx := strings.Index(stem, "."+streamIncremental+"."); idx >= 0 {
+			name, base = stem[:idx], stem[idx+len(streamIncremental)+2:]
+			if !zp.opts.IsMcbackup(base) {
+				continue
+			}
+		} else if strings.HasSuffix(stem, "."+streamFull) {
+			name = strings.TrimSuffix(stem, "."+streamFull)
+		} else {
+			continue
+		}
+		if !zp.opts.IsMcbackup(name) {
+			continue
+		}
+		when, err := zp.opts.ParseBackupName(name)
+		if err != nil {
+			continue
+		}
+
+		streams = append(streams, zfsStream{
+			name: name,
+			when: when,
+			path: filepath.Join(zp.SendDir, file.Name()),
+			base: base,
+		})
+	}
+	return streams, nil
+}
+
+// pruneStreams removes streams from the send directory that aren't needed
+// to import any snapshot that is still held, other than the one deleted
+func (zp *ZfsProvider) pruneStreams(deleted string) error {
+	log := logrus.WithField("prefix", "zfs")
+
+	streams, err := zp.readStreams()
+	if err != nil {
+		return err
+	}
+	snaps, err := zp.List()
+	if err != nil {
+		return err
+	}
+	held := make(map[string]bool)
+	for _, snap := range snaps {
+		if snap.Name() != deleted {
+			held[snap.Name()] = true
+		}
+	}
+
+	needed := neededStreams(streams, held)
+	for _, stream := range streams {
+		if needed[stream.path] {
+			continue
+		}
+		log.Debugf("removing stream %s", filepath.Base(stream.path))
+		err = os.Remove(stream.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// neededStreams finds the paths of the streams needed to import any of the
+// held snapshots. Streams of held snapshots are kept even if they can't be
+// imported, as the streams they depend upon may be restored from elsewhere.
+func neededStreams(streams []zfsStream, held map[string]bool) map[string]bool {
+	needed := make(map[string]bool)
+	for _, stream := range streams {
+		if !held[stream.name] {
+			continue
+		}
+		needed[stream.path] = true
+		chain, err := streamChain(streams, stream.name)
+		if err != nil {
+			continue
+		}
+		for _, s := range chain {
+			needed[s.path] = true
+		}
+	}
+	return needed
+}
+
+// streamChain finds the streams needed to receive the named snapshot, or
+// the latest if name is empty: a full stream, followed by each incremental
+// stream sent from the one before, up to and including the named snapshot
+func streamChain(streams []zfsStream, name string) ([]zfsStream, error) {
+	if len(streams) == 0 {
+		return nil, errors.New("no zfs send streams found to import")
+	}
+	if name == "" {
+		latest := streams[0]
+		for _, stream := range streams {
+			if stream.when.After(latest.when) {
+				latest = stream
+			}
+		}
+		name = latest.name
+	}
+
+	// A full stream of a snapshot is preferred to an incremental one
+	byName := make(map[string]zfsStream)
+	for _, stream := range streams {
+		if prev, ok := byName[stream.name]; !ok || !prev.full() {
+			byName[stream.name] = stream
+		}
+	}
+
+	var chain []zfsStream
+	for next := name; ; {
+		stream, ok := byName[next]
+		if !ok && next == name {
+			return nil, fmt.Errorf("no zfs send stream found for '%s'", name)
+		} else if !ok {
+			return nil, fmt.Errorf("no zfs send stream found for '%s', which '%s' was sent incrementally from",
+				next, chain[len(chain)-1].name)
+		}
+		// Bases are always older, but guard against a loop of bad names
+		if len(chain) > 0 && !stream.when.Before(chain[len(chain)-1].when) {
+			return nil, fmt.Errorf("zfs send stream for '%s' has a base that isn't older", chain[len(chain)-1].name)
+		}
+
+		chain = append(chain, stream)
+		if stream.full() {
+			break
+		}
+		next = stream.base
+	}
+
+	// Streams are received oldest first
+	sort.Slice(chain, func(i, j int) bool {
+		return chain[i].when.Before(chain[j].when)
+	})
+	return chain, nil
+}
+
+var _ Importer = &ZfsProvider{}
+var _ Exporter = &ZfsProvider{}
@@ -0,0 +1,146 @@
+package provider
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spritsail/mcbackup/config"
+)
+
+func TestIncrementalBase(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2021, 1, 1, hour, 0, 0, 0, time.UTC)
+	}
+	sent := []sentSnapshot{
+		{name: "mcb-01", when: at(1), depth: 0},
+		{name: "mcb-03", when: at(3), depth: 1},
+		{name: "mcb-02", when: at(2), depth: 4},
+	}
+
+	base, depth := incrementalBase(sent, at(4))
+	if base != "mcb-03" || depth != 2 {
+		t.Errorf("expected to send from mcb-03 at depth 2, got %q at %d", base, depth)
+	}
+	base, depth = incrementalBase(sent, at(3))
+	if base != "mcb-02" || depth != 5 {
+		t.Errorf("expected to send from mcb-02 at depth 5, got %q at %d", base, depth)
+	}
+	base, depth = incrementalBase(sent, at(1))
+	if base != "" || depth != 0 {
+		t.Errorf("expected a full stream, got %q at %d", base, depth)
+	}
+}
+
+func TestStreamChain(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2021, 1, 1, hour, 0, 0, 0, time.UTC)
+	}
+	streams := []zfsStream{
+		{name: "mcb-04", when: at(4), base: "mcb-02"},
+		{name: "mcb-01", when: at(1)},
+		{name: "mcb-03", when: at(3)},
+		{name: "mcb-03", when: at(3), base: "mcb-02"},
+		{name: "mcb-02", when: at(2), base: "mcb-01"},
+		{name: "mcb-00", when: at(0), base: "mcb-01"},
+		{name: "mcb-01b", when: at(1), base: "mcb-99"},
+	}
+
+	names := func(chain []zfsStream) (names []string) {
+		for _, stream := range chain {
+			names = append(names, stream.name)
+		}
+		return
+	}
+
+	for name, expected := range map[string][]string{
+		"":       {"mcb-01", "mcb-02", "mcb-04"},
+		"mcb-02": {"mcb-01", "mcb-02"},
+		"mcb-03": {"mcb-03"},
+	} {
+		chain, err := streamChain(streams, name)
+		if err != nil {
+			t.Errorf("%q: %v", name, err)
+			continue
+		}
+		if got := names(chain); fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Errorf("%q: expected chain %v, got %v", name, expected, got)
+		}
+	}
+
+	// Streams can't be received without the stream they were sent from, nor
+	// from a base that isn't older
+	for _, name := range []string{"mcb-00", "mcb-01b", "mcb-05"} {
+		_, err := streamChain(streams, name)
+		if err == nil {
+			t.Errorf("%q: expected no chain to be found", name)
+		}
+	}
+}
+
+func TestReadStreams(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"mcb-2021-01-01-01:00.full.zfs",
+		"mcb-2021-01-01-02:00.incr.mcb-2021-01-01-01:00.zfs",
+		"mcb-2021-01-01-03:00.incr.mcb-2021-01-01-02:00.zfs.partial",
+		"mcb-2021-01-01-03:00.incr.other.zfs",
+		"mcb-2021-01-01-03:00.zfs",
+		"other.full.zfs",
+	} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	zp := &ZfsProvider{
+		opts:    &config.Options{BackupPrefix: "mcb-", BackupFormat: "%F-%H:%M"},
+		SendDir: dir,
+	}
+	streams, err := zp.readStreams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 2 {
+		t.Fatalf("expected 2 streams, got %v", streams)
+	}
+	if streams[0].name != "mcb-2021-01-01-01:00" || !streams[0].full() {
+		t.Errorf("expected a full stream of mcb-2021-01-01-01:00, got %v", streams[0])
+	}
+	if streams[1].name != "mcb-2021-01-01-02:00" || streams[1].base != "mcb-2021-01-01-01:00" {
+		t.Errorf("expected mcb-2021-01-01-02:00 sent from mcb-2021-01-01-01:00, got %v", streams[1])
+	}
+}
+
+func TestNeededStreams(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2021, 1, 1, hour, 0, 0, 0, time.UTC)
+	}
+	streams := []zfsStream{
+		{name: "mcb-01", when: at(1), path: "1"},
+		{name: "mcb-02", when: at(2), path: "2", base: "mcb-01"},
+		{name: "mcb-03", when: at(3), path: "3", base: "mcb-02"},
+		{name: "mcb-04", when: at(4), path: "4"},
+		{name: "mcb-05", when: at(5), path: "5", base: "mcb-04"},
+		{name: "mcb-07", when: at(7), path: "7", base: "mcb-06"},
+	}
+
+	// Older snapshots are still needed by those sent from them, but not once
+	// nothing held was sent from them
+	needed := neededStreams(streams, map[string]bool{
+		"mcb-03": true,
+		"mcb-05": true,
+		"mcb-07": true,
+	})
+	if got := fmt.Sprint(needed); got != "map[1:true 2:true 3:true 4:true 5:true 7:true]" {
+		t.Errorf("expected every stream to be needed, got %s", got)
+	}
+
+	needed = neededStreams(streams, map[string]bool{"mcb-05": true})
+	if got := fmt.Sprint(needed); got != "map[4:true 5:true]" {
+		t.Errorf("expected only mcb-04 and mcb-05 to be needed, got %s", got)
+	}
+}
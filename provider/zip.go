@@ -0,0 +1,289 @@
+package provider
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
+	"github.com/spritsail/mcbackup/backup"
+	"github.com/spritsail/mcbackup/config"
+)
+
+const zipExtension = "zip"
+
+type ZipProvider struct {
+	ArchiveProvider
+	opts   *config.Options
+	method uint16
+	Method string `long:"zip-method" description:"compression method used for files in the zip archive" env:"ZIP_METHOD" default:"deflate" choice:"deflate" choice:"store"`
+	Level  int    `long:"zip-level" description:"level of the deflate compression, -1 (default) or 0 (none) to 9 (best)" env:"ZIP_LEVEL" default:"-1"`
+}
+
+func NewZip(args []string, opts *config.Options) (p Provider, remain []string, err error) {
+	var zipOpts ZipProvider
+	zipOpts.opts = opts
+
+	parser := flags.NewParser(&zipOpts, flags.IgnoreUnknown)
+	err = opts.ApplyConfig(parser, "zip")
+	if err != nil {
+		return
+	}
+	remain, err = parser.ParseArgs(args)
+	if err != nil {
+		return
+	}
+
+	// Attempt to initialise archive-global options
+	err = zipOpts.InitArchive()
+	if err != nil {
+		return
+	}
+
+	switch zipOpts.Method {
+	case "deflate":
+		zipOpts.method = zip.Deflate
+	case "store":
+		zipOpts.method = zip.Store
+	default:
+		err = fmt.Errorf("unknown compression method '%s'", zipOpts.Method)
+		return
+	}
+
+	if zipOpts.Level < flate.DefaultCompression || zipOpts.Level > flate.BestCompression {
+		err = fmt.Errorf("invalid compression level %d for zip, must be between %d and %d",
+			zipOpts.Level, flate.DefaultCompression, flate.BestCompression)
+		return
+	}
+
+	p = &zipOpts
+	return
+}
+
+func (zp *ZipProvider) Create(ctx context.Context, name string, when time.Time) (backup.Backup, error) {
+	log := logrus.WithField("prefix", "zip")
+	log.WithField("filename", name+"."+zp.archiveExt(zipExtension)).Debugf("creating zip backup")
+
+	manifest := backup.NewManifest(name, when)
+	return zp.storeArchive(name, when, zp.archiveExt(zipExtension), func(out io.Writer) error {
+		return zp.writeZip(ctx, out, manifest)
+	}, manifest)
+}
+
+// Replicate stores a copy of a backup from another provider
+func (zp *ZipProvider) Replicate(ctx context.Context, src Provider, bkup backup.Backup) (backup.Backup, error) {
+	ext := zp.archiveExt(zipExtension)
+	return replicate(ctx, src, bkup, ext, zp.archiveDir,
+		func(write func(out io.Writer) error, m *backup.Manifest) (backup.Backup, error) {
+			return zp.storeArchive(bkup.Name(), bkup.When(), ext, write, m)
+		})
+}
+
+// archiveDir writes a zip archive of a directory other than the source,
+// with the same options
+func (zp *ZipProvider) archiveDir(ctx context.Context, out io.Writer, dir, root string, m *backup.Manifest) error {
+	other := *zp
+	other.SourceDirectory = dir
+	other.root = root
+	return other.writeZip(ctx, out, m)
+}
+
+// writeZip streams a zip archive of the source directory to out,
+// encrypted if configured, recording each file written in the manifest
+func (zp *ZipProvider) writeZip(ctx context.Context, out io.Writer, m *backup.Manifest) (err error) {
+	ew, err := zp.encrypt(out)
+	if err != nil {
+		return fmt.Errorf("encrypting: %v", err)
+	}
+	zw := zip.NewWriter(ew)
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, zp.Level)
+	})
+
+	err = zp.walkSource(func(fpath, name string, info os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return zp.writeEntry(ctx, zw, m, fpath, name, info)
+	})
+	if err != nil {
+		zw.Close()
+		ew.Close()
+		return
+	}
+
+	err = zw.Close()
+	if e := ew.Close(); err == nil {
+		err = e
+	}
+	return
+}
+
+func (zp *ZipProvider) writeEntry(ctx context.Context, zw *zip.Writer, m *backup.Manifest, fpath, name string, info os.FileInfo) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return fmt.Errorf("%s: making header: %v", fpath, err)
+	}
+	header.Name = name
+	rel := stripTopLevel(name)
+
+	switch mode := info.Mode(); {
+	case mode.IsDir():
+		// Directories are denoted by a trailing slash and have no content
+		header.Name += "/"
+		header.Method = zip.Store
+		_, err = zw.CreateHeader(header)
+		if err != nil || rel == "" {
+			return err
+		}
+		_, err = m.Add(rel, info, "", nil)
+		return err
+
+	case mode&os.ModeSymlink != 0:
+		// Symlinks store the link target as the file content
+		target, err := os.Readlink(fpath)
+		if err != nil {
+			return err
+		}
+		header.Method = zip.Store
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, target)
+		if err != nil {
+			return err
+		}
+		_, err = m.Add(rel, info, target, nil)
+		return err
+
+	case mode.IsRegular():
+		header.Method = zp.method
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(fpath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		// The manifest reads the file through to the archive whilst hashing it
+		_, err = m.Add(rel, info, "", io.TeeReader(ctxReader{ctx, file}, w))
+		if err != nil {
+			return fmt.Errorf("%s: copying contents: %v", fpath, err)
+		}
+		return nil
+
+	default:
+		// Sockets, devices and pipes have no place in a backup
+		logrus.WithField("prefix", "zip").
+			WithField("file", fpath).
+			Debug("skipping irregular file")
+		return nil
+	}
+}
+
+func (zp *ZipProvider) List() (backup.Backups, error) {
+	return zp.listArchives(zp.opts, zipExtension)
+}
+
+func (zp *ZipProvider) Restore(bkup backup.Backup, dest string) (err error) {
+	ab, ok := bkup.(*ArchiveBackup)
+	if !ok {
+		return fmt.Errorf("backup %s is not a zip archive", bkup.Name())
+	}
+
+	if dest == "" {
+		_, err = moveAside(zp.SourceDirectory, zp.BackupDirectory)
+		if err != nil {
+			return
+		}
+		dest = zp.SourceDirectory
+	}
+	logrus.WithField("prefix", "zip").
+		WithField("filename", ab.path).
+		WithField("dest", dest).
+		Debugf("restoring zip backup")
+
+	ex := newExtractor(dest)
+	if err := zp.readZip(ab.path, ex.extract); err != nil {
+		return err
+	}
+	return ex.finish()
+}
+
+func (zp *ZipProvider) Verify(bkup backup.Backup) error {
+	ab, ok := bkup.(*ArchiveBackup)
+	if !ok {
+		return fmt.Errorf("backup %s is not a zip archive", bkup.Name())
+	}
+
+	return zp.verifyArchive(ab, func(fn entryFunc) error {
+		return zp.readZip(ab.path, fn)
+	})
+}
+
+// readZip calls fn for every file in a zip archive
+func (zp *ZipProvider) readZip(filepath string, fn entryFunc) error {
+	if isEncrypted(filepath) {
+		// Zip archives can't be read as a stream
+		decrypted, err := zp.decryptArchive(filepath)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(decrypted)
+		filepath = decrypted
+	}
+
+	zr, err := zip.OpenReader(filepath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		err = readZipEntry(zf, fn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readZipEntry(zf *zip.File, fn entryFunc) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return fmt.Errorf("%s: opening: %v", zf.Name, err)
+	}
+	defer rc.Close()
+
+	info := zf.FileInfo()
+	var linkname string
+	if info.Mode()&os.ModeSymlink != 0 {
+		// Symlink targets are stored as the file content
+		target, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return err
+		}
+		linkname = string(target)
+	}
+
+	return fn(stripTopLevel(zf.Name), info, linkname, rc)
+}
+
+func (zp *ZipProvider) Source() string {
+	return zp.SourceDirectory
+}
+
+var _ Provider = &ZipProvider{}
+var _ Opener = &ZipProvider{}
+var _ Capacity = &ZipProvider{}
+var _ Replica = &ZipProvider{}